@@ -0,0 +1,41 @@
+package hornet
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// NewCompilationCache returns a wazero compilation cache that stores compiled
+// modules in the given directory, so that restarting the host doesn't need to
+// compile plugins again. Pass it to
+// wazero.RuntimeConfig.WithCompilationCache when creating the runtime.
+//
+// Cached modules are keyed by the SHA-256 hash of the module and the version
+// of wazero, so a rebuilt plugin or an upgraded host is compiled again. If dir
+// is empty, the cache is stored in the directory "hornet" in the user's cache
+// directory (see os.UserCacheDir).
+//
+// The cache can be shared by multiple runtimes and should be closed once all
+// of them are closed. It's the way to avoid compiling a module again in
+// [NewPool], [NewSupervisor] and [Manager.Load], which take the source of the
+// module and compile it themselves.
+func NewCompilationCache(dir string) (wazero.CompilationCache, error) {
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user cache directory: %w", err)
+		}
+
+		dir = filepath.Join(userDir, "hornet")
+	}
+
+	cache, err := wazero.NewCompilationCacheWithDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compilation cache in %q: %w", dir, err)
+	}
+
+	return cache, nil
+}
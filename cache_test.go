@@ -0,0 +1,32 @@
+package hornet
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/tetratelabs/wazero"
+)
+
+func TestNewCompilationCache(t *testing.T) {
+	t.Run("should store compiled modules in directory", func(t *testing.T) {
+		ctx := context.Background()
+		is := is.New(t)
+		dir := t.TempDir()
+
+		cache, err := NewCompilationCache(dir)
+		is.NoErr(err)
+		t.Cleanup(func() { _ = cache.Close(ctx) })
+
+		r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(cache))
+		t.Cleanup(func() { _ = r.Close(ctx) })
+
+		_, err = r.CompileModule(ctx, minimalMemoryModule)
+		is.NoErr(err)
+
+		entries, err := os.ReadDir(dir)
+		is.NoErr(err)
+		is.True(len(entries) > 0)
+	})
+}
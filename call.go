@@ -0,0 +1,145 @@
+package hornet
+
+import (
+	"reflect"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// protoCodecName is the name of the only codec supported by Hornet.
+const protoCodecName = "proto"
+
+// callInfo contains the call options of an RPC performed by the ClientConn.
+type callInfo struct {
+	// header and trailer are set by grpc.Header and grpc.Trailer.
+	header  *metadata.MD
+	trailer *metadata.MD
+	// peer is set by grpc.Peer.
+	peer *peer.Peer
+	// maxSendMsgSize and maxRecvMsgSize limit the size of messages. They
+	// default to the limits of the ClientConn.
+	maxSendMsgSize int
+	maxRecvMsgSize int
+	// onFinish contains the functions set by grpc.OnFinish.
+	onFinish []func(error)
+}
+
+// newCallInfo applies the call options on top of the given message size
+// limits. Options that don't make sense for a Wasm module, like compression or
+// credentials, return an error with the code Unimplemented instead of being
+// silently ignored.
+func newCallInfo(opts []grpc.CallOption, maxSendMsgSize, maxRecvMsgSize int) (callInfo, error) {
+	ci := callInfo{
+		maxSendMsgSize: maxSendMsgSize,
+		maxRecvMsgSize: maxRecvMsgSize,
+	}
+
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			ci.header = o.HeaderAddr
+		case grpc.TrailerCallOption:
+			ci.trailer = o.TrailerAddr
+		case grpc.PeerCallOption:
+			ci.peer = o.PeerAddr
+		case grpc.MaxSendMsgSizeCallOption:
+			ci.maxSendMsgSize = o.MaxSendMsgSize
+		case grpc.MaxRecvMsgSizeCallOption:
+			ci.maxRecvMsgSize = o.MaxRecvMsgSize
+		case grpc.OnFinishCallOption:
+			ci.onFinish = append(ci.onFinish, o.OnFinish)
+		case grpc.ForceCodecCallOption:
+			if o.Codec.Name() != protoCodecName {
+				return ci, unsupportedCallOptionError(o, "only the proto codec is supported")
+			}
+		case grpc.ForceCodecV2CallOption:
+			if o.CodecV2.Name() != protoCodecName {
+				return ci, unsupportedCallOptionError(o, "only the proto codec is supported")
+			}
+		case grpc.CustomCodecCallOption:
+			if o.Codec.String() != protoCodecName {
+				return ci, unsupportedCallOptionError(o, "only the proto codec is supported")
+			}
+		case grpc.ContentSubtypeCallOption:
+			if !strings.EqualFold(o.ContentSubtype, protoCodecName) {
+				return ci, unsupportedCallOptionError(o, "only the proto content subtype is supported")
+			}
+		case grpc.FailFastCallOption, grpc.StaticMethodCallOption, grpc.MaxRetryRPCBufferSizeCallOption:
+			// The Wasm module is always ready and calls are never retried, so
+			// these options have no effect.
+		default:
+			if !embedsEmptyCallOption(o) {
+				return ci, unsupportedCallOptionError(o, "")
+			}
+			// Custom options embedding grpc.EmptyCallOption are ignored, the
+			// same way as in gRPC.
+		}
+	}
+
+	return ci, nil
+}
+
+func unsupportedCallOptionError(o grpc.CallOption, reason string) error {
+	if reason != "" {
+		reason = ": " + reason
+	}
+
+	return status.Errorf(codes.Unimplemented, "call option %T is not supported by Hornet%s", o, reason)
+}
+
+// embedsEmptyCallOption reports if the call option is a struct embedding
+// grpc.EmptyCallOption.
+func embedsEmptyCallOption(o grpc.CallOption) bool {
+	v := reflect.Indirect(reflect.ValueOf(o))
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+
+	f, ok := v.Type().FieldByName("EmptyCallOption")
+
+	return ok && f.Anonymous && f.Type == reflect.TypeFor[grpc.EmptyCallOption]()
+}
+
+func (ci callInfo) setHeader(md metadata.MD) {
+	if ci.header != nil {
+		*ci.header = md
+	}
+}
+
+func (ci callInfo) setTrailer(md metadata.MD) {
+	if ci.trailer != nil {
+		*ci.trailer = md
+	}
+}
+
+// setPeer sets the peer to the Wasm module with the given name.
+func (ci callInfo) setPeer(moduleName string) {
+	if ci.peer != nil {
+		*ci.peer = peer.Peer{Addr: moduleAddr(moduleName)}
+	}
+}
+
+// finish calls the functions set by grpc.OnFinish.
+func (ci callInfo) finish(err error) {
+	for _, fn := range ci.onFinish {
+		fn(err)
+	}
+}
+
+// checkSendMsgSize returns an error with the code ResourceExhausted if the
+// message is larger than the maximum send message size.
+func (ci callInfo) checkSendMsgSize(m proto.Message) error {
+	return checkSendMsgSize(proto.Size(m), ci.maxSendMsgSize)
+}
+
+// moduleAddr is the net.Addr of a Wasm module, reported using grpc.Peer.
+type moduleAddr string
+
+func (a moduleAddr) Network() string { return "wasm" }
+func (a moduleAddr) String() string  { return string(a) }
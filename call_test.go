@@ -0,0 +1,86 @@
+package hornet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type testCallOption struct {
+	grpc.EmptyCallOption
+}
+
+type testCodec struct{}
+
+func (testCodec) Marshal(any) ([]byte, error) { return nil, nil }
+func (testCodec) Unmarshal([]byte, any) error { return nil }
+func (testCodec) Name() string                { return "json" }
+
+var _ encoding.Codec = testCodec{}
+
+func TestNewCallInfo(t *testing.T) {
+	t.Run("should apply supported options", func(t *testing.T) {
+		is := is.New(t)
+
+		var (
+			header, trailer metadata.MD
+			p               peer.Peer
+			finishErr       = errors.New("not called")
+		)
+
+		ci, err := newCallInfo([]grpc.CallOption{
+			grpc.Header(&header),
+			grpc.Trailer(&trailer),
+			grpc.Peer(&p),
+			grpc.OnFinish(func(err error) { finishErr = err }),
+			grpc.WaitForReady(true),
+			grpc.CallContentSubtype("proto"),
+			testCallOption{},
+		}, defaultMaxSendMsgSize, defaultMaxRecvMsgSize)
+		is.NoErr(err)
+
+		ci.setHeader(metadata.Pairs("h", "1"))
+		ci.setTrailer(metadata.Pairs("t", "2"))
+		ci.setPeer("plugin")
+		ci.finish(nil)
+
+		is.Equal(header, metadata.Pairs("h", "1"))
+		is.Equal(trailer, metadata.Pairs("t", "2"))
+		is.Equal(p.Addr.Network(), "wasm")
+		is.Equal(p.Addr.String(), "plugin")
+		is.NoErr(finishErr)
+	})
+
+	t.Run("should reject unsupported options", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, o := range []grpc.CallOption{
+			grpc.UseCompressor("gzip"),
+			grpc.ForceCodec(testCodec{}),
+			grpc.CallContentSubtype("json"),
+		} {
+			_, err := newCallInfo([]grpc.CallOption{o}, defaultMaxSendMsgSize, defaultMaxRecvMsgSize)
+			is.Equal(status.Code(err), codes.Unimplemented)
+		}
+	})
+
+	t.Run("should override message size limits", func(t *testing.T) {
+		is := is.New(t)
+
+		ci, err := newCallInfo([]grpc.CallOption{grpc.MaxCallSendMsgSize(4)}, 1, 2)
+		is.NoErr(err)
+
+		is.Equal(ci.maxSendMsgSize, 4)
+		is.Equal(ci.maxRecvMsgSize, 2)
+		is.NoErr(ci.checkSendMsgSize(wrapperspb.String("a")))
+		is.Equal(status.Code(ci.checkSendMsgSize(wrapperspb.String("abcdef"))), codes.ResourceExhausted)
+	})
+}
@@ -4,48 +4,128 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"log/slog"
 	"os"
-	"sync"
+	"slices"
+	"sync/atomic"
+	"time"
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/sys"
 	spb "google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
 
 type clientOptions struct {
-	logger *slog.Logger
+	logger     *slog.Logger
+	hostServer *Server
+	// unaryInt is the interceptor wrapping calls to Invoke. After the options
+	// are applied, it contains the chain of all unary interceptors.
+	unaryInt       grpc.UnaryClientInterceptor
+	chainUnaryInts []grpc.UnaryClientInterceptor
+	// streamInt is the interceptor wrapping calls to NewStream. After the
+	// options are applied, it contains the chain of all stream interceptors.
+	streamInt       grpc.StreamClientInterceptor
+	chainStreamInts []grpc.StreamClientInterceptor
+	// maxSendMsgSize and maxRecvMsgSize are the default message size limits,
+	// they can be overridden per call using call options.
+	maxSendMsgSize int
+	maxRecvMsgSize int
+	// deadlineMargin is subtracted from the timeout sent to the Wasm module.
+	deadlineMargin time.Duration
+	// memoryLimitPages is the memory limit applied by
+	// InstantiateModuleAndClient, memory is the resulting memory of the
+	// module.
+	memoryLimitPages uint32
+	memory           *limitedMemory
+	// stdio configures the stdout and stderr of modules instantiated by
+	// InstantiateModuleAndClient, output is the resulting output of the
+	// module.
+	stdio  stdioOptions
+	output *moduleOutput
+	// moduleConfig and fsConfig contain the functions applied to the module
+	// config and its file system config by InstantiateModuleAndClient.
+	moduleConfig []func(wazero.ModuleConfig) wazero.ModuleConfig
+	fsConfig     []func(wazero.FSConfig) wazero.FSConfig
 }
 
+// defaultDeadlineMargin is the default margin between the deadline seen by the
+// Wasm module and the deadline of the host, see WithDeadlineMargin.
+const defaultDeadlineMargin = 50 * time.Millisecond
+
 var defaultClientOptions = clientOptions{
-	logger: slog.Default(),
+	logger:         slog.Default(),
+	maxSendMsgSize: defaultMaxSendMsgSize,
+	maxRecvMsgSize: defaultMaxRecvMsgSize,
+	deadlineMargin: defaultDeadlineMargin,
+	stdio: stdioOptions{
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
+		stdoutLevel: slog.LevelInfo,
+		stderrLevel: slog.LevelWarn,
+	},
 }
 
 var _ grpc.ClientConnInterface = &ClientConn{}
 
+// errModuleClosed is returned by calls on a ClientConn whose module is closed.
+var errModuleClosed = status.Error(codes.Unavailable, "module is closed")
+
 // ClientConn represents a virtual connection to a Wasm module, to perform RPCs.
 // It can be passed to gRPC client constructors generated by protoc-gen-go-grpc.
 //
 // A ClientConn is safe for concurrent use, though individual calls to Invoke
 // are serialized to ensure that only one call is in-flight to the Wasm module
-// at a time.
+// at a time. Callers waiting for their turn give up once their context is
+// done.
+//
+// To interrupt a call that is already running in the Wasm module when its
+// context is done, the runtime needs to be created with
+// wazero.RuntimeConfig.WithCloseOnContextDone. Interrupting a call closes the
+// module, after that the ClientConn is unusable and calls fail with the code
+// Unavailable.
 type ClientConn struct {
 	opts   clientOptions
 	module api.Module
 
-	// m guards calls to the Wasm module.
-	m         sync.Mutex
+	// sem guards calls to the Wasm module. It is a channel with a buffer of
+	// size 1 instead of a mutex, so that waiting for it can be interrupted by
+	// the context.
+	sem       chan struct{}
 	mallocFn  api.Function
 	commandFn api.Function
+	// version is the negotiated protocol version, commandFn is used for
+	// protocolV1 and commandV2Fn for later versions.
+	version     uint32
+	features    feature
+	commandV2Fn api.Function
+	// Stream functions are optional, they are nil if the module does not
+	// support streams.
+	streamOpenFn      api.Function
+	streamSendFn      api.Function
+	streamCloseSendFn api.Function
+	streamRecvFn      api.Function
+	streamCloseFn     api.Function
 	// buf is the buffer used to communicate with the Wasm module.
 	buf []byte
+	// hdrBuf is the buffer used to encode request headers.
+	hdrBuf []byte
+	// lastCallID is the ID of the last call sent to the module.
+	lastCallID uint64
 	// modulePointer is the pointer to the buffer in the Wasm module. It is used
 	// to write data to the Wasm module.
 	modulePointer uint32
+	// hostResp is the response of the last host command called by the Wasm
+	// module, waiting to be copied into the module's memory.
+	hostResp []byte
+	// memorySize is the size of the module's memory after the last call.
+	memorySize atomic.Uint64
 }
 
 // InstantiateModuleAndClient is a utility function that instantiates a Wasm
@@ -54,7 +134,22 @@ type ClientConn struct {
 //
 // The module is configured to initialize the reactor by calling the _initialize
 // function upon instantiation. The module's stdout and stderr are directed to
-// the host's stdout and stderr.
+// the host's stdout and stderr, and the module uses the system clocks instead
+// of the deterministic clocks used by wazero by default. The host module that
+// exposes host services to the plugin is instantiated in the runtime if it
+// does not exist yet, see [InstantiateHostModule].
+//
+// The memory of the module can be limited using [WithMemoryLimitPages]. The
+// output of the module can be redirected using [WithStdout] and [WithStderr],
+// prefixed using [WithStdioPrefix], logged using [WithStdioLogging] and tagged
+// with the method of the call that wrote it using [WithStdioCallMethod]. The
+// configuration of the module, e.g. its environment variables, arguments and
+// file system, can be changed using the options starting with WithModule, see
+// [WithModuleConfig].
+//
+// The module is compiled on every call, use [InstantiateCompiledModuleAndClient]
+// to instantiate a module compiled once, and [NewCompilationCache] to reuse
+// compiled modules across restarts.
 //
 // Use this function when you want to quickly instantiate a Wasm module and
 // create a gRPC client for it. If you need more control over the module
@@ -73,47 +168,159 @@ func InstantiateModuleAndClient[T any](
 ) (api.Module, T, error) {
 	var zeroT T
 
-	// Configure the module to initialize the reactor. Pipe stdout and stderr to
-	// the host's stdout and stderr without making the resource unavailable.
-	config := wazero.NewModuleConfig().
-		WithStdout(pipeWriter(os.Stdout)).
-		WithStderr(pipeWriter(os.Stderr)).
-		WithStartFunctions("_initialize")
-
-	// Instantiate the module.
-	wasmModule, err := runtime.InstantiateWithConfig(ctx, source, config)
+	compiled, err := runtime.CompileModule(ctx, source)
 	if err != nil {
-		return nil, zeroT, fmt.Errorf("failed to instantiate Wasm module: %w", err)
+		return nil, zeroT, fmt.Errorf("failed to compile Wasm module: %w", err)
 	}
+	// Closing the compiled module is safe, the instantiated module keeps
+	// working until it's closed.
+	defer compiled.Close(ctx)
 
-	// Instantiate client.
-	client, err := NewClient(wasmModule, opt...)
+	return InstantiateCompiledModuleAndClient(ctx, runtime, compiled, newClient, opt...)
+}
+
+// InstantiateCompiledModuleAndClient is like [InstantiateModuleAndClient], but
+// instantiates an already compiled module, so that the module does not need to
+// be compiled again. This is useful when instantiating the same module
+// multiple times, e.g. when restarting a plugin.
+//
+// The compiled module must be compiled by the same runtime. It is not closed
+// by this function, the caller is responsible for closing it once it is no
+// longer needed, instantiated modules keep working after that.
+func InstantiateCompiledModuleAndClient[T any](
+	ctx context.Context,
+	runtime wazero.Runtime,
+	compiled wazero.CompiledModule,
+	newClient func(grpc.ClientConnInterface) T,
+	opt ...ClientOption,
+) (api.Module, T, error) {
+	var zeroT T
+
+	wasmModule, client, err := instantiateClient(ctx, runtime, compiled, newModuleConfig(), opt)
 	if err != nil {
-		_ = wasmModule.Close(ctx)
-		return nil, zeroT, fmt.Errorf("failed to instantiate grpc client: %w", err)
+		return nil, zeroT, err
 	}
 
 	return wasmModule, newClient(client), nil
 }
 
-// pipeWriter takes a writer and returns a new writer that writes to an io.Pipe.
-// The pipe is copied to the original writer in a background goroutine.
-func pipeWriter(w io.Writer) io.Writer {
-	pr, pw := io.Pipe()
+// newModuleConfig returns the configuration of modules instantiated by Hornet.
+// It configures the module to initialize the reactor. Stdout and stderr are
+// piped to the host's stdout and stderr without making the resource
+// unavailable. The module uses the system clocks, so that deadlines propagated
+// to the module expire in real time.
+func newModuleConfig() wazero.ModuleConfig {
+	return wazero.NewModuleConfig().
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithStartFunctions(initializeFunctionName)
+}
+
+// initializeFunctionName is the name of the function that initializes the
+// reactor, it's called when the module is instantiated.
+const initializeFunctionName = "_initialize"
+
+// applyModuleConfig applies the module config options to config. The start
+// function is set afterwards, so that the options can't prevent the reactor
+// from being initialized.
+func (o *clientOptions) applyModuleConfig(config wazero.ModuleConfig) wazero.ModuleConfig {
+	for _, fn := range o.moduleConfig {
+		config = fn(config)
+	}
+
+	if len(o.fsConfig) > 0 {
+		fsConfig := wazero.NewFSConfig()
+		for _, fn := range o.fsConfig {
+			fsConfig = fn(fsConfig)
+		}
+
+		config = config.WithFSConfig(fsConfig)
+	}
+
+	return config.WithStartFunctions(initializeFunctionName)
+}
+
+// instantiateClient instantiates the compiled module using the config and
+// creates a client for it. It makes sure the host module exists and applies
+// the module config, the memory limit and the stdio configuration from the
+// options.
+func instantiateClient(
+	ctx context.Context,
+	runtime wazero.Runtime,
+	compiled wazero.CompiledModule,
+	config wazero.ModuleConfig,
+	opt []ClientOption,
+) (api.Module, *ClientConn, error) {
+	// Make sure the host functions imported by the module exist.
+	_, err := InstantiateHostModule(ctx, runtime)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := defaultClientOptions
+	for _, o := range opt {
+		o.applyClient(&opts)
+	}
+
+	config = opts.applyModuleConfig(config)
+
+	// Route the output of the module. Every instance gets its own writers, so
+	// that its output can be tagged with the method of its current call.
+	output := newModuleOutput(opts.stdio, opts.logger)
+	config = config.WithStdout(output.stdout).WithStderr(output.stderr)
+	opt = append(slices.Clip(opt), clientOptionFunc(func(opt *clientOptions) { opt.output = output }))
+
+	// Flush incomplete lines once the module is closed.
+	instantiateCtx := experimental.WithCloseNotifier(ctx, experimental.CloseNotifyFunc(
+		func(context.Context, uint32) { output.flush() },
+	))
+
+	// Limit the memory of the module, if requested.
+	var mem *limitedMemory
+	if opts.memoryLimitPages > 0 {
+		mem = newLimitedMemory(opts.memoryLimitPages)
+
+		err = mem.checkCompiledModule(compiled)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		instantiateCtx = experimental.WithMemoryAllocator(instantiateCtx, mem)
+		opt = append(slices.Clip(opt), clientOptionFunc(func(opt *clientOptions) { opt.memory = mem }))
+	}
 
-	go func() {
-		_, err := io.Copy(w, pr)
-		// Close the pipe reader with any error from copying
-		pr.CloseWithError(err)
-	}()
+	// Instantiate the module.
+	wasmModule, err := runtime.InstantiateModule(instantiateCtx, compiled, config)
+	if err != nil {
+		output.flush()
+
+		if mem != nil && mem.exceeded.Load() {
+			err = ErrMemoryLimitExceeded
+		}
+
+		return nil, nil, fmt.Errorf("failed to instantiate Wasm module: %w", err)
+	}
+
+	// Instantiate client.
+	client, err := NewClient(wasmModule, opt...)
+	if err != nil {
+		_ = wasmModule.Close(ctx)
+		return nil, nil, fmt.Errorf("failed to instantiate grpc client: %w", err)
+	}
 
-	return pw
+	return wasmModule, client, nil
 }
 
 // NewClient creates a new gRPC client that communicates with the given Wasm
-// module. The module must export the functions hornet-v1-malloc and
-// hornet-v1-command. The returned client is safe for concurrent use by
-// multiple goroutines.
+// module. The returned client is safe for concurrent use by multiple
+// goroutines.
+//
+// The client negotiates the protocol version with the module by calling the
+// exported function hornet-version and uses the highest version supported by
+// both sides. Modules that don't export hornet-version are expected to export
+// hornet-v1-malloc and hornet-v1-command, and don't support metadata,
+// deadlines and streams.
 //
 // The ClientConn is valid until the module is closed. Closing the module
 // invalidates the ClientConn; future calls to Invoke will return an error.
@@ -123,31 +330,124 @@ func NewClient(module api.Module, opt ...ClientOption) (*ClientConn, error) {
 		o.applyClient(&opts)
 	}
 
+	chainUnaryClientInterceptors(&opts)
+	chainStreamClientInterceptors(&opts)
+
+	if module.Memory() == nil {
+		return nil, errors.New("wasm module does not export its memory")
+	}
+
 	mallocFn, err := getExportedFunction(module, mallocFunctionDefinition)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get malloc function: %w", err)
 	}
 
-	commandFn, err := getExportedFunction(module, commandFunctionDefinition)
+	c := &ClientConn{
+		opts:     opts,
+		module:   module,
+		sem:      make(chan struct{}, 1),
+		mallocFn: mallocFn,
+	}
+	c.memorySize.Store(uint64(module.Memory().Size()))
+
+	err = c.negotiate(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	if c.version == protocolV1 {
+		c.commandFn, err = getExportedFunction(module, commandFunctionDefinition)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get command function: %w", err)
+		}
+
+		return c, nil
+	}
+
+	c.commandV2Fn, err = getExportedFunction(module, commandV2FunctionDefinition)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get command function: %w", err)
 	}
 
-	c := &ClientConn{
-		opts:      opts,
-		module:    module,
-		mallocFn:  mallocFn,
-		commandFn: commandFn,
+	if c.features&featureStreams != 0 {
+		err = c.initStreamFunctions()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return c, nil
 }
 
-// NewStream is not supported in this client.
-//
-//nolint:lll // This method is just a stub to satisfy the grpc.ClientConnInterface interface.
-func (c *ClientConn) NewStream(context.Context, *grpc.StreamDesc, string, ...grpc.CallOption) (grpc.ClientStream, error) {
-	return nil, errors.New("streams are not supported by Wasm")
+// negotiate determines the protocol version and the features supported by
+// both the host and the module. It must be called before the ClientConn is
+// used by other goroutines.
+func (c *ClientConn) negotiate(ctx context.Context) error {
+	versionFn, err := getOptionalExportedFunction(c.module, versionFunctionDefinition)
+	if err != nil {
+		return fmt.Errorf("failed to get version function: %w", err)
+	}
+
+	if versionFn == nil {
+		c.version = protocolV1
+		return nil
+	}
+
+	results, err := c.call(ctx, versionFn)
+	if err != nil {
+		return err
+	}
+
+	c.version = min(api.DecodeU32(results[0]), protocolVersion)
+	if c.version < protocolV1 {
+		return fmt.Errorf("unsupported protocol version %d", c.version)
+	}
+
+	if c.version == protocolV1 {
+		return nil
+	}
+
+	featuresFn, err := getExportedFunction(c.module, featuresFunctionDefinition)
+	if err != nil {
+		return fmt.Errorf("failed to get features function: %w", err)
+	}
+
+	results, err = c.call(ctx, featuresFn)
+	if err != nil {
+		return err
+	}
+
+	c.features = feature(results[0])
+
+	c.opts.logger.DebugContext(ctx, "negotiated protocol with Wasm module",
+		"version", c.version, "features", c.features)
+
+	return nil
+}
+
+// initStreamFunctions retrieves the stream functions from the module.
+func (c *ClientConn) initStreamFunctions() error {
+	fns := []struct {
+		fn  *api.Function
+		def functionDefinition
+	}{
+		{&c.streamOpenFn, streamOpenFunctionDefinition},
+		{&c.streamSendFn, streamSendFunctionDefinition},
+		{&c.streamCloseSendFn, streamCloseSendFunctionDefinition},
+		{&c.streamRecvFn, streamRecvFunctionDefinition},
+		{&c.streamCloseFn, streamCloseFunctionDefinition},
+	}
+
+	for _, f := range fns {
+		fn, err := getExportedFunction(c.module, f.def)
+		if err != nil {
+			return fmt.Errorf("failed to get stream function: %w", err)
+		}
+
+		*f.fn = fn
+	}
+
+	return nil
 }
 
 // Invoke performs a unary RPC into the underlying Wasm module and returns after
@@ -155,6 +455,29 @@ func (c *ClientConn) NewStream(context.Context, *grpc.StreamDesc, string, ...grp
 // directly, instead, use the generated client code from protoc-gen-go-grpc to
 // make RPCs.
 //
+// The outgoing metadata and the deadline of ctx are sent to the Wasm module,
+// the plugin can observe them in the context passed to the handler. The
+// deadline is sent as a timeout relative to the current time, the module
+// should use the system clocks for the timeout to be accurate (see
+// wazero.ModuleConfig.WithSysNanotime). The module sees the deadline slightly
+// earlier than the host, so that a handler honoring its context can return
+// before the call is interrupted, see [WithDeadlineMargin]. The header and
+// trailer metadata sent by the module can be retrieved using the grpc.Header
+// and grpc.Trailer call options. Modules that only support the v1 protocol
+// don't receive nor send any metadata or deadlines.
+//
+// The grpc.Peer, grpc.MaxCallSendMsgSize, grpc.MaxCallRecvMsgSize and
+// grpc.OnFinish call options are supported as well. Codec options are only
+// accepted for the proto codec, and options without an effect on a Wasm
+// module, like grpc.WaitForReady, are ignored. Other options, e.g.
+// grpc.UseCompressor or grpc.PerRPCCredentials, fail the call with the code
+// Unimplemented.
+//
+// Unary interceptors configured using WithUnaryInterceptor and
+// WithChainUnaryInterceptor are called before the RPC is performed. As there is
+// no underlying grpc.ClientConn, the interceptors receive a nil
+// *grpc.ClientConn.
+//
 // Invoke is safe for concurrent use by multiple goroutines, but calls to
 // Invoke are serialized to ensure that only one call is in-flight to the Wasm
 // module at a time.
@@ -162,7 +485,22 @@ func (c *ClientConn) Invoke(
 	ctx context.Context,
 	method string,
 	req, resp any,
-	_ ...grpc.CallOption, // Options are currently ignored.
+	opts ...grpc.CallOption,
+) error {
+	if c.opts.unaryInt != nil {
+		return c.opts.unaryInt(ctx, method, req, resp, nil, c.invoke, opts...)
+	}
+
+	return c.invoke(ctx, method, req, resp, nil, opts...)
+}
+
+// invoke is the grpc.UnaryInvoker called at the end of the interceptor chain.
+func (c *ClientConn) invoke(
+	ctx context.Context,
+	method string,
+	req, resp any,
+	_ *grpc.ClientConn,
+	opts ...grpc.CallOption,
 ) error {
 	reqMsg, ok := req.(proto.Message)
 	if !ok {
@@ -174,59 +512,227 @@ func (c *ClientConn) Invoke(
 		return fmt.Errorf("invalid response type: expected proto.Message, got %T", req)
 	}
 
-	if c.module.IsClosed() {
-		return errors.New("module is closed")
+	ci, err := newCallInfo(opts, c.opts.maxSendMsgSize, c.opts.maxRecvMsgSize)
+	if err != nil {
+		return err
 	}
 
-	return c.invoke(ctx, method, reqMsg, respMsg)
+	err = c.invokeProto(ctx, method, reqMsg, respMsg, ci)
+	ci.finish(err)
+
+	return err
 }
 
-func (c *ClientConn) invoke(
+func (c *ClientConn) invokeProto(
 	ctx context.Context,
 	method string,
 	req proto.Message,
 	resp proto.Message,
+	ci callInfo,
 ) error {
-	c.m.Lock()
-	defer c.m.Unlock()
+	err := c.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if c.opts.stdio.method {
+		ctx = contextWithCallMethod(ctx, method)
+	}
 
-	logger := c.opts.logger.With("method", method)
+	// Don't call into the module if the deadline already expired.
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+
+	err = ci.checkSendMsgSize(req)
+	if err != nil {
+		return err
+	}
 
-	// Step 1: Allocate memory in the Wasm module if needed.
-	if msgSize := proto.Size(req) + len(method); cap(c.buf) < msgSize {
-		logger.DebugContext(ctx, "memory buffer is too small, reallocating using malloc function")
+	ci.setPeer(c.module.Name())
 
-		err := c.invokeMalloc(ctx, msgSize)
+	if c.version == protocolV1 {
+		// Step 1: Write the request to the buffer in the Wasm module.
+		c.hdrBuf = append(c.hdrBuf[:0], method...)
+
+		err := c.writeRequest(ctx, c.hdrBuf, req)
 		if err != nil {
 			return err
 		}
+
+		// Step 2: Call the Wasm command function.
+		return c.invokeCommand(ctx, len(method), resp, ci)
 	}
 
-	// Step 2: Write the request to the buffer in the Wasm module.
-	err := c.writeRequestToModule(method, req)
+	// Step 1: Write the request envelope to the buffer in the Wasm module.
+	err = c.writeRequest(ctx, c.appendRequestHeader(ctx, method), req)
 	if err != nil {
 		return err
 	}
 
-	// Step 3: Call the Wasm command function.
-	err = c.invokeCommand(ctx, method, resp)
-	if err != nil {
-		return err
+	// Step 2: Call the Wasm command function.
+	return c.invokeCommandV2(ctx, len(c.hdrBuf), resp, ci)
+}
+
+// appendRequestHeader encodes the request header containing the method name,
+// a new call ID and, if supported by the module, the outgoing metadata and the
+// deadline from the context into c.hdrBuf and returns it. The caller must hold
+// the lock.
+func (c *ClientConn) appendRequestHeader(ctx context.Context, method string) []byte {
+	c.lastCallID++
+	hdr := requestHeader{method: method, callID: c.lastCallID}
+
+	if c.features&featureMetadata != 0 {
+		hdr.metadata, _ = metadata.FromOutgoingContext(ctx)
+
+		if deadline, ok := ctx.Deadline(); ok {
+			hdr.timeout = moduleTimeout(time.Until(deadline), c.opts.deadlineMargin)
+		}
+	}
+
+	c.hdrBuf = hdr.appendTo(c.hdrBuf[:0])
+
+	return c.hdrBuf
+}
+
+// moduleTimeout returns the timeout sent to the Wasm module for a call with the
+// given time remaining until its deadline. The module sees the deadline
+// earlier by the margin, but at most by half of the remaining time, so that it
+// can return before the host interrupts the call.
+func moduleTimeout(remaining, margin time.Duration) time.Duration {
+	// Make sure the timeout is not zero, as that means no deadline.
+	return max(remaining-min(margin, remaining/2), 1)
+}
+
+// writeRequest writes the prefix followed by the request to the buffer in the
+// Wasm module, allocating more memory in the module if needed. The prefix is
+// either the method name or the request header. The caller must hold the lock.
+func (c *ClientConn) writeRequest(ctx context.Context, prefix []byte, req proto.Message) error {
+	if msgSize := proto.Size(req) + len(prefix); cap(c.buf) < msgSize {
+		c.opts.logger.DebugContext(ctx, "memory buffer is too small, reallocating using malloc function")
+
+		err := c.invokeMalloc(ctx, msgSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.writeRequestToModule(prefix, req)
+}
+
+// lock acquires the exclusive right to call into the Wasm module. It returns
+// an error if the context is done before the lock is acquired or if the module
+// is closed.
+func (c *ClientConn) lock(ctx context.Context) error {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+
+	if c.module.IsClosed() {
+		<-c.sem
+		return errModuleClosed
 	}
 
 	return nil
 }
 
+func (c *ClientConn) unlock() {
+	<-c.sem
+}
+
+// call calls the function exported by the Wasm module. The ClientConn is stored
+// in the context passed to the module, so that host functions called by the
+// module during the call can access it. The caller must hold the lock.
+//
+// If the runtime is configured to close modules when the context is done
+// (see wazero.RuntimeConfig.WithCloseOnContextDone), the call is interrupted
+// once ctx is done. In that case the module is closed, the returned error
+// contains the code Canceled or DeadlineExceeded, and all further calls fail
+// with the code Unavailable.
+//
+// If the module traps or exits, it is closed as well, see [Supervisor] for
+// restarting it.
+func (c *ClientConn) call(ctx context.Context, fn api.Function, params ...uint64) ([]uint64, error) {
+	if c.opts.memory != nil {
+		c.opts.memory.exceeded.Store(false)
+	}
+
+	if c.opts.output != nil {
+		c.opts.output.begin(callMethodFromContext(ctx))
+		defer c.opts.output.end()
+	}
+
+	results, err := fn.Call(contextWithClientConn(ctx, c), params...)
+	if err != nil {
+		name := exportedFunctionName(fn)
+
+		if c.opts.memory != nil && c.opts.memory.exceeded.Load() {
+			// The module is in an unknown state after failing to allocate
+			// memory, it must not be called again.
+			closeErr := c.module.Close(context.WithoutCancel(ctx))
+			c.opts.logger.WarnContext(ctx, "Wasm module exceeded its memory limit, module is closed",
+				"function", name, "limit", c.opts.memory.limit, "error", err, "close_error", closeErr)
+
+			return nil, ErrMemoryLimitExceeded
+		}
+
+		var exitErr *sys.ExitError
+		if errors.As(err, &exitErr) {
+			switch exitErr.ExitCode() {
+			case sys.ExitCodeContextCanceled:
+				c.opts.logger.WarnContext(ctx, "Wasm function call was cancelled, module is closed", "function", name)
+				return nil, status.Errorf(codes.Canceled, "call to Wasm function %q was cancelled", name)
+			case sys.ExitCodeDeadlineExceeded:
+				c.opts.logger.WarnContext(ctx, "Wasm function call exceeded deadline, module is closed", "function", name)
+				return nil, status.Errorf(codes.DeadlineExceeded, "call to Wasm function %q exceeded deadline", name)
+			}
+		}
+
+		if !c.module.IsClosed() {
+			// The module trapped, its state is undefined after the call was
+			// aborted, so it must not be called again.
+			closeErr := c.module.Close(context.WithoutCancel(ctx))
+			c.opts.logger.WarnContext(ctx, "Wasm function call failed, module is closed",
+				"function", name, "error", err, "close_error", closeErr)
+		}
+
+		return nil, fmt.Errorf("failed to call Wasm function %q: %w", name, err)
+	}
+
+	c.memorySize.Store(uint64(c.module.Memory().Size()))
+
+	return results, nil
+}
+
+// MemoryUsage returns the size of the Wasm module's linear memory in bytes, as
+// observed after the last call into the module. Wasm memory never shrinks, so
+// this is the peak memory usage of the module. It is safe to call while a
+// call into the module is in progress.
+func (c *ClientConn) MemoryUsage() uint64 {
+	return c.memorySize.Load()
+}
+
 func (c *ClientConn) invokeMalloc(ctx context.Context, msgSize int) error {
-	results, err := c.mallocFn.Call(
+	results, err := c.call(
 		ctx,
+		c.mallocFn,
 		api.EncodeU32(uint32(msgSize)), //nolint:gosec // no risk of overflow
 	)
 	if err != nil {
-		return fmt.Errorf("failed to call Wasm function %q: %w", c.mallocFn.Definition().Name(), err)
+		return err
+	}
+
+	ptr := api.DecodeU32(results[0])
+
+	err = checkMemoryBounds(c.module.Memory(), ptr, uint32(msgSize)) //nolint:gosec // no risk of overflow
+	if err != nil {
+		return err
 	}
 
-	c.modulePointer = api.DecodeU32(results[0])
+	c.modulePointer = ptr
 
 	if cap(c.buf) < msgSize {
 		c.buf = make([]byte, msgSize)
@@ -235,9 +741,8 @@ func (c *ClientConn) invokeMalloc(ctx context.Context, msgSize int) error {
 	return nil
 }
 
-func (c *ClientConn) writeRequestToModule(method string, req proto.Message) error {
-	c.buf = c.buf[:len(method)]
-	copy(c.buf, method)
+func (c *ClientConn) writeRequestToModule(prefix []byte, req proto.Message) error {
+	c.buf = append(c.buf[:0], prefix...)
 
 	reqBytes, err := proto.MarshalOptions{}.MarshalAppend(c.buf, req)
 	if err != nil {
@@ -253,44 +758,130 @@ func (c *ClientConn) writeRequestToModule(method string, req proto.Message) erro
 	return nil
 }
 
-func (c *ClientConn) invokeCommand(ctx context.Context, method string, resp proto.Message) error {
-	results, err := c.commandFn.Call(
+func (c *ClientConn) invokeCommand(ctx context.Context, methodSize int, resp proto.Message, ci callInfo) error {
+	results, err := c.call(
 		ctx,
+		c.commandFn,
 		api.EncodeU32(c.modulePointer),
-		api.EncodeU32(uint32(len(method))), //nolint:gosec // no risk of overflow
-		api.EncodeU32(uint32(len(c.buf))),  //nolint:gosec // no risk of overflow
+		api.EncodeU32(uint32(methodSize)), //nolint:gosec // no risk of overflow
+		api.EncodeU32(uint32(len(c.buf))), //nolint:gosec // no risk of overflow
 	)
 	if err != nil {
-		return fmt.Errorf("failed to call Wasm function %q: %w", c.commandFn.Definition().Name(), err)
+		return err
 	}
 
-	// Check the results of the function call.
-	ptrSize := results[0]
+	respBytes, err := c.readResponse(results[0])
+	if err != nil {
+		return err
+	}
+
+	return decodeResponse(respBytes[0], respBytes[1:], resp, ci.maxRecvMsgSize)
+}
+
+func (c *ClientConn) invokeCommandV2(ctx context.Context, headerSize int, resp proto.Message, ci callInfo) error {
+	typ, hdr, payload, err := c.callEnvelope(
+		ctx,
+		c.commandV2Fn,
+		api.EncodeU32(c.modulePointer),
+		api.EncodeU32(uint32(headerSize)), //nolint:gosec // no risk of overflow
+		api.EncodeU32(uint32(len(c.buf))), //nolint:gosec // no risk of overflow
+	)
+	if err != nil {
+		return err
+	}
+
+	ci.setHeader(hdr.header)
+	ci.setTrailer(hdr.trailer)
+
+	return decodeResponse(typ, payload, resp, ci.maxRecvMsgSize)
+}
+
+// callEnvelope calls the function exported by the Wasm module and parses the
+// returned response envelope. The returned payload is a view into the module's
+// memory and is only valid until the next call into the module. The caller
+// must hold the lock.
+func (c *ClientConn) callEnvelope(
+	ctx context.Context,
+	fn api.Function,
+	params ...uint64,
+) (byte, responseHeader, []byte, error) {
+	results, err := c.call(ctx, fn, params...)
+	if err != nil {
+		return 0, responseHeader{}, nil, err
+	}
+
+	respBytes, err := c.readResponse(results[0])
+	if err != nil {
+		return 0, responseHeader{}, nil, err
+	}
+
+	typ, hdr, payload, err := parseResponseEnvelope(respBytes)
+	if err != nil {
+		return 0, responseHeader{}, nil, fmt.Errorf("failed to parse response from Wasm module: %w", err)
+	}
+
+	return typ, hdr, payload, nil
+}
+
+// readResponse reads the response from the module's memory. The pointer and
+// size of the response are packed in ptrSize, where the higher 32 bits are the
+// pointer and the lower 32 bits are the size. The returned slice is a view
+// into the module's memory and is only valid until the next call into the
+// module.
+func (c *ClientConn) readResponse(ptrSize uint64) ([]byte, error) {
 	ptr := uint32(ptrSize >> 32) //nolint:gosec // higher 32 bits
 	size := uint32(ptrSize)      //nolint:gosec // lower 32 bits
 
+	// Don't trust the module, make sure the response is within its memory.
+	err := checkMemoryBounds(c.module.Memory(), ptr, size)
+	if err != nil {
+		return nil, err
+	}
+
 	// Read the byte slice from the module's memory.
 	respBytes, ok := c.module.Memory().Read(ptr, size)
 	if !ok {
-		return fmt.Errorf("failed to read from Wasm module memory at pointer %d with size %d", ptr, size)
+		return nil, fmt.Errorf("failed to read from Wasm module memory at pointer %d with size %d", ptr, size)
 	}
 
 	if len(respBytes) == 0 {
-		return errors.New("received empty response from Wasm module")
+		return nil, errors.New("received empty response from Wasm module")
 	}
 
-	if respBytes[0] == 1 {
-		// Error response.
-		var st spb.Status
-		if err := proto.Unmarshal(respBytes[1:], &st); err != nil {
-			return fmt.Errorf("failed to unmarshal protobuf error response: %w", err)
+	return respBytes, nil
+}
+
+// decodeResponse decodes the payload of a response with the given type
+// returned by the Wasm module into resp. If the module returned an error, the
+// error is returned as a gRPC status error. Responses larger than
+// maxRecvMsgSize are rejected before they are decoded.
+func decodeResponse(typ byte, payload []byte, resp proto.Message, maxRecvMsgSize int) error {
+	switch typ {
+	case responseOK:
+		if err := checkRecvMsgSize(len(payload), maxRecvMsgSize); err != nil {
+			return err
+		}
+
+		if err := proto.Unmarshal(payload, resp); err != nil {
+			return fmt.Errorf("failed to unmarshal protobuf command response: %w", err)
 		}
-		return status.ErrorProto(&st) //nolint:wrapcheck // We want to preserve the original error.
+
+		return nil
+	case responseError:
+		return decodeErrorResponse(payload)
+	default:
+		return fmt.Errorf("received unexpected response type %d from Wasm module", typ)
 	}
+}
 
-	if err := proto.Unmarshal(respBytes[1:], resp); err != nil {
-		return fmt.Errorf("failed to unmarshal protobuf command response: %w", err)
+// decodeErrorResponse decodes the payload of an error response returned by the
+// Wasm module and returns it as a gRPC status error. Registered errors are
+// reconstructed, see RegisterError.
+func decodeErrorResponse(payload []byte) error {
+	var st spb.Status
+	if err := proto.Unmarshal(payload, &st); err != nil {
+		return fmt.Errorf("failed to unmarshal protobuf error response: %w", err)
 	}
 
-	return nil
+	return errorFromStatus(status.FromProto(&st))
 }
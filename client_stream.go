@@ -0,0 +1,644 @@
+package hornet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// NewStream begins a streaming RPC into the underlying Wasm module. This method
+// is not meant to be called directly, instead, use the generated client code
+// from protoc-gen-go-grpc to make RPCs.
+//
+// Server-streaming RPCs are opened in the Wasm module when the request
+// message is sent, all other streams are opened right away. Messages are
+// pushed into and pulled from the module one by one as SendMsg and RecvMsg are
+// called. Cancelling the context closes the stream in the Wasm module.
+//
+// The outgoing metadata and the deadline of ctx are sent to the Wasm module
+// when the stream is opened. See [ClientConn.Invoke] for the supported call
+// options.
+//
+// Stream interceptors configured using WithStreamInterceptor and
+// WithChainStreamInterceptor are called before the stream is created. As there
+// is no underlying grpc.ClientConn, the interceptors receive a nil
+// *grpc.ClientConn.
+func (c *ClientConn) NewStream(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	method string,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	if c.opts.streamInt != nil {
+		return c.opts.streamInt(ctx, desc, nil, method, c.newStream, opts...)
+	}
+
+	return c.newStream(ctx, desc, nil, method, opts...)
+}
+
+// newStream is the grpc.Streamer called at the end of the interceptor chain.
+func (c *ClientConn) newStream(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	_ *grpc.ClientConn,
+	method string,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	if c.streamOpenFn == nil {
+		return nil, status.Error(codes.Unimplemented, "streams are not supported by the Wasm module")
+	}
+
+	if c.module.IsClosed() {
+		return nil, errModuleClosed
+	}
+
+	ci, err := newCallInfo(opts, c.opts.maxSendMsgSize, c.opts.maxRecvMsgSize)
+	if err != nil {
+		return nil, err
+	}
+
+	ci.setPeer(c.module.Name())
+
+	if c.opts.stdio.method {
+		ctx = contextWithCallMethod(ctx, method)
+	}
+
+	cs := &clientStream{
+		ctx:    ctx,
+		conn:   c,
+		desc:   desc,
+		method: method,
+		ci:     ci,
+		sent:   make(chan struct{}, 1),
+	}
+
+	if desc.ClientStreams {
+		err := cs.open(nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return cs, nil
+}
+
+var (
+	// errNeedInput is returned by ClientConn.recvStream if the stream handler
+	// in the Wasm module is waiting for the next message from the host.
+	errNeedInput = errors.New("stream handler is waiting for input")
+	// errPending is returned by ClientConn.recvStream if the stream handler in
+	// the Wasm module did not send a message yet, as it is waiting on
+	// something other than the host.
+	errPending = errors.New("stream handler is pending")
+)
+
+// While the stream handler in the Wasm module can't make progress, RecvMsg
+// polls the stream with a delay that starts at minStreamPollInterval and
+// doubles up to maxStreamPollInterval.
+const (
+	minStreamPollInterval = time.Millisecond
+	maxStreamPollInterval = 100 * time.Millisecond
+)
+
+// clientStream implements grpc.ClientStream on top of the stream functions
+// exported by the Wasm module.
+type clientStream struct {
+	ctx    context.Context
+	conn   *ClientConn
+	desc   *grpc.StreamDesc
+	method string
+	ci     callInfo
+
+	// sent is signalled when a message is sent or the request stream is
+	// closed. RecvMsg waits for it when the stream handler needs input.
+	sent chan struct{}
+
+	mu         sync.Mutex // guards following fields
+	id         uint32
+	opened     bool
+	closedSend bool
+	// header is the header metadata sent by the stream handler, it is set
+	// once the first message or the end of the stream is received.
+	header         metadata.MD
+	headerReceived bool
+	// buffered is the message pulled by Header before the header was
+	// received, it is returned by the next call to RecvMsg.
+	buffered    []byte
+	hasBuffered bool
+	// trailer is the trailer metadata sent by the stream handler, it is set
+	// once the end of the stream is received.
+	trailer metadata.MD
+	// err is the terminal error of the stream. Once set, it is returned by all
+	// subsequent calls to RecvMsg.
+	err error
+	// stop stops the context.AfterFunc that closes the stream when the context
+	// is cancelled.
+	stop func() bool
+}
+
+var _ grpc.ClientStream = (*clientStream)(nil)
+
+// Header returns the header metadata sent by the stream handler. Like in gRPC,
+// it blocks until the header is received, which happens together with the
+// first message or at the end of the stream. As messages are pulled from the
+// Wasm module on demand, Header pulls the first message itself if RecvMsg
+// wasn't called yet, and buffers it until the next call to RecvMsg.
+func (cs *clientStream) Header() (metadata.MD, error) {
+	cs.mu.Lock()
+	header, received := cs.header, cs.headerReceived
+	cs.mu.Unlock()
+
+	if received {
+		return header, nil
+	}
+
+	err := cs.recv(nil)
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.headerReceived {
+		return cs.header, nil
+	}
+
+	return nil, err
+}
+
+// Trailer returns the trailer metadata sent by the stream handler. It must
+// only be called after RecvMsg returned a non-nil error.
+func (cs *clientStream) Trailer() metadata.MD {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.trailer
+}
+
+func (cs *clientStream) Context() context.Context { return cs.ctx }
+
+// SendMsg sends m to the stream in the Wasm module. For server-streaming RPCs
+// it opens the stream with m as the request, and must be called exactly once,
+// before any calls to RecvMsg.
+//
+// If the stream was already terminated, io.EOF is returned and the status of
+// the stream can be discovered using RecvMsg.
+func (cs *clientStream) SendMsg(m any) error {
+	req, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("invalid request type: expected proto.Message, got %T", m)
+	}
+
+	if err := cs.ci.checkSendMsgSize(req); err != nil {
+		return err
+	}
+
+	if !cs.desc.ClientStreams {
+		return cs.open(req)
+	}
+
+	cs.mu.Lock()
+	id, closedSend, err := cs.id, cs.closedSend, cs.err
+	cs.mu.Unlock()
+
+	switch {
+	case closedSend:
+		return status.Error(codes.Internal, "SendMsg called after CloseSend")
+	case err != nil:
+		return io.EOF
+	case cs.ctx.Err() != nil:
+		cs.cancel()
+		return io.EOF
+	}
+
+	err = cs.conn.sendStream(cs.ctx, id, req)
+	switch {
+	case errors.Is(err, io.EOF):
+		// The stream handler already returned.
+		return io.EOF
+	case err != nil:
+		cs.finish(err)
+		return err
+	}
+
+	notify(cs.sent)
+
+	return nil
+}
+
+// CloseSend closes the request stream in the Wasm module. It is a no-op for
+// server-streaming RPCs, as their request stream is closed after the first
+// message.
+func (cs *clientStream) CloseSend() error {
+	if !cs.desc.ClientStreams {
+		return nil
+	}
+
+	cs.mu.Lock()
+	if cs.closedSend || cs.err != nil {
+		cs.mu.Unlock()
+		return nil
+	}
+
+	cs.closedSend = true
+	id := cs.id
+	cs.mu.Unlock()
+
+	err := cs.conn.closeSendStream(cs.ctx, id)
+	if err != nil {
+		cs.finish(err)
+		return err
+	}
+
+	notify(cs.sent)
+
+	return nil
+}
+
+// RecvMsg pulls the next message from the stream in the Wasm module into m. It
+// returns io.EOF once the stream is finished successfully. If the stream
+// handler is waiting for input or on anything else, RecvMsg polls the stream
+// until the handler sends a message, and retries right away when a message is
+// sent or the request stream is closed. The Wasm module is not locked while
+// waiting, so the stream can be cancelled and other calls can be made.
+func (cs *clientStream) RecvMsg(m any) error {
+	resp, ok := m.(proto.Message)
+	if !ok {
+		return fmt.Errorf("invalid response type: expected proto.Message, got %T", m)
+	}
+
+	cs.mu.Lock()
+	msg, buffered := cs.buffered, cs.hasBuffered
+	cs.buffered, cs.hasBuffered = nil, false
+	cs.mu.Unlock()
+
+	if buffered {
+		if err := proto.Unmarshal(msg, resp); err != nil {
+			return fmt.Errorf("failed to unmarshal protobuf command response: %w", err)
+		}
+
+		return nil
+	}
+
+	return cs.recv(resp)
+}
+
+// recv pulls the next message from the stream in the Wasm module into resp. If
+// resp is nil, the message is buffered, see Header.
+func (cs *clientStream) recv(resp proto.Message) error {
+	delay := minStreamPollInterval
+
+	for {
+		cs.mu.Lock()
+		id, opened, err := cs.id, cs.opened, cs.err
+		cs.mu.Unlock()
+
+		switch {
+		case err != nil:
+			return err
+		case !opened:
+			return status.Error(codes.Internal, "RecvMsg called before SendMsg on a server stream")
+		case cs.ctx.Err() != nil:
+			cs.cancel()
+			return cs.terminalError()
+		}
+
+		hdr, msg, err := cs.conn.recvStream(cs.ctx, id, resp, cs.ci)
+		if hdr != nil {
+			cs.setMetadata(hdr)
+		}
+
+		if !errors.Is(err, errNeedInput) && !errors.Is(err, errPending) {
+			if err != nil {
+				cs.finish(err)
+				return cs.terminalError()
+			}
+
+			if resp == nil {
+				cs.mu.Lock()
+				cs.buffered, cs.hasBuffered = msg, true
+				cs.mu.Unlock()
+			}
+
+			return nil
+		}
+
+		// Even if the stream handler is waiting for input, another goroutine
+		// in the Wasm module could still send a message, so we keep polling.
+		timer := time.NewTimer(delay)
+		select {
+		case <-cs.sent:
+			delay = minStreamPollInterval
+		case <-timer.C:
+			delay = min(2*delay, maxStreamPollInterval)
+		case <-cs.ctx.Done():
+		}
+
+		timer.Stop()
+	}
+}
+
+// setMetadata records the header and trailer metadata contained in the
+// response header. The header is recorded once, the trailer is only sent at
+// the end of the stream.
+func (cs *clientStream) setMetadata(hdr *responseHeader) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if !cs.headerReceived {
+		cs.headerReceived = true
+		cs.header = hdr.header
+		cs.ci.setHeader(hdr.header)
+	}
+
+	if hdr.trailer != nil {
+		cs.trailer = hdr.trailer
+		cs.ci.setTrailer(hdr.trailer)
+	}
+}
+
+// open opens the stream in the Wasm module. The request is only used for
+// server-streaming RPCs and is nil otherwise.
+func (cs *clientStream) open(req proto.Message) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if cs.opened || cs.err != nil {
+		return status.Error(codes.Internal, "SendMsg called multiple times on a server stream")
+	}
+
+	if err := cs.ctx.Err(); err != nil {
+		cs.err = status.FromContextError(err).Err()
+		cs.ci.finish(cs.err)
+
+		return cs.err
+	}
+
+	id, err := cs.conn.openStream(cs.ctx, cs.method, req)
+	if err != nil {
+		cs.err = err
+		cs.ci.finish(err)
+
+		return err
+	}
+
+	cs.id = id
+	cs.opened = true
+	cs.stop = context.AfterFunc(cs.ctx, cs.cancel)
+
+	return nil
+}
+
+// cancel is called when the stream context is cancelled.
+func (cs *clientStream) cancel() {
+	cs.finish(status.FromContextError(cs.ctx.Err()).Err())
+}
+
+// finish marks the stream as finished with the given error and closes the
+// stream in the Wasm module. Only the first call has an effect.
+func (cs *clientStream) finish(err error) {
+	cs.mu.Lock()
+	if cs.err != nil {
+		cs.mu.Unlock()
+		return
+	}
+
+	cs.err = err
+	cs.stop()
+	cs.mu.Unlock()
+
+	if errors.Is(err, io.EOF) {
+		cs.ci.finish(nil)
+	} else {
+		cs.ci.finish(err)
+	}
+
+	// The module already released the stream if it returned io.EOF or a
+	// status, closing it again is a no-op. We still close it to make sure
+	// the stream is released in case the error originated in the host.
+	closeErr := cs.conn.closeStream(context.WithoutCancel(cs.ctx), cs.id)
+	if closeErr != nil {
+		cs.conn.opts.logger.DebugContext(cs.ctx, "failed to close stream", "method", cs.method, "error", closeErr)
+	}
+}
+
+func (cs *clientStream) terminalError() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	return cs.err
+}
+
+// openStream opens a stream in the Wasm module with the given request and
+// returns the ID of the stream. The request is nil if the method is not a
+// server-streaming RPC.
+func (c *ClientConn) openStream(ctx context.Context, method string, req proto.Message) (uint32, error) {
+	err := c.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer c.unlock()
+
+	err = c.writeRequest(ctx, c.appendRequestHeader(ctx, method), req)
+	if err != nil {
+		return 0, err
+	}
+
+	typ, _, payload, err := c.callEnvelope(
+		ctx,
+		c.streamOpenFn,
+		api.EncodeU32(c.modulePointer),
+		api.EncodeU32(uint32(len(c.hdrBuf))), //nolint:gosec // no risk of overflow
+		api.EncodeU32(uint32(len(c.buf))),    //nolint:gosec // no risk of overflow
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	switch typ {
+	case responseOK:
+		if len(payload) != 4 {
+			return 0, fmt.Errorf("received invalid stream ID of size %d from Wasm module", len(payload))
+		}
+
+		return binary.LittleEndian.Uint32(payload), nil
+	case responseError:
+		return 0, decodeErrorResponse(payload)
+	default:
+		return 0, fmt.Errorf("received unexpected response type %d from Wasm module", typ)
+	}
+}
+
+// sendStream sends the request to the stream with the given ID. It returns
+// io.EOF if the stream handler already returned.
+func (c *ClientConn) sendStream(ctx context.Context, id uint32, req proto.Message) error {
+	err := c.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	err = c.writeRequest(ctx, nil, req)
+	if err != nil {
+		return err
+	}
+
+	typ, _, payload, err := c.callEnvelope(
+		ctx,
+		c.streamSendFn,
+		api.EncodeU32(id),
+		api.EncodeU32(c.modulePointer),
+		api.EncodeU32(uint32(len(c.buf))), //nolint:gosec // no risk of overflow
+	)
+	if err != nil {
+		return err
+	}
+
+	switch typ {
+	case responseOK:
+		return nil
+	case responseEOF:
+		return io.EOF
+	case responseError:
+		return decodeErrorResponse(payload)
+	default:
+		return fmt.Errorf("received unexpected response type %d from Wasm module", typ)
+	}
+}
+
+// closeSendStream closes the request stream of the stream with the given ID.
+func (c *ClientConn) closeSendStream(ctx context.Context, id uint32) error {
+	err := c.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	_, err = c.call(ctx, c.streamCloseSendFn, api.EncodeU32(id))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// recvStream pulls the next message from the stream with the given ID into
+// resp. It returns io.EOF if the stream is finished, errNeedInput if the
+// stream handler is waiting for the next message from the host and errPending
+// if it's waiting on something else. The returned response header is nil if no
+// response was received from the stream handler. If resp is nil, the message
+// is not unmarshalled, instead a copy of it is returned.
+func (c *ClientConn) recvStream(
+	ctx context.Context,
+	id uint32,
+	resp proto.Message,
+	ci callInfo,
+) (*responseHeader, []byte, error) {
+	err := c.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer c.unlock()
+
+	typ, hdr, payload, err := c.callEnvelope(ctx, c.streamRecvFn, api.EncodeU32(id))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch typ {
+	case responseEOF:
+		return &hdr, nil, io.EOF
+	case responseNeedInput:
+		return nil, nil, errNeedInput
+	case responsePending:
+		return nil, nil, errPending
+	case responseOK:
+		if resp != nil {
+			break
+		}
+
+		if err := checkRecvMsgSize(len(payload), ci.maxRecvMsgSize); err != nil {
+			return &hdr, nil, err
+		}
+
+		// The payload is a view into the module's memory, it needs to be
+		// copied to outlive this call.
+		return &hdr, bytes.Clone(payload), nil
+	}
+
+	return &hdr, nil, decodeResponse(typ, payload, resp, ci.maxRecvMsgSize)
+}
+
+// closeStream closes the stream with the given ID in the Wasm module. Closing
+// a stream that is already closed is a no-op.
+func (c *ClientConn) closeStream(ctx context.Context, id uint32) error {
+	err := c.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	_, err = c.call(ctx, c.streamCloseFn, api.EncodeU32(id))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// releaseStream wraps a stream on a connection that is borrowed for the
+// duration of the stream, e.g. an instance of a Pool. It releases the
+// connection once the stream is finished or its context is done.
+type releaseStream struct {
+	grpc.ClientStream
+
+	// release releases the connection, only the first call has an effect. stop
+	// stops the context.AfterFunc calling release.
+	release func()
+	stop    func() bool
+}
+
+func newReleaseStream(ctx context.Context, cs grpc.ClientStream, release func()) *releaseStream {
+	rs := &releaseStream{ClientStream: cs}
+	rs.release = sync.OnceFunc(release)
+	rs.stop = context.AfterFunc(ctx, rs.release)
+
+	return rs
+}
+
+// RecvMsg releases the connection once the stream is finished.
+func (rs *releaseStream) RecvMsg(m any) error {
+	err := rs.ClientStream.RecvMsg(m)
+	if err != nil {
+		rs.finish()
+	}
+
+	return err
+}
+
+// SendMsg releases the connection if sending failed. If the stream was
+// terminated, io.EOF is returned and the connection is released by RecvMsg,
+// which returns the status of the stream.
+func (rs *releaseStream) SendMsg(m any) error {
+	err := rs.ClientStream.SendMsg(m)
+	if err != nil && !errors.Is(err, io.EOF) {
+		rs.finish()
+	}
+
+	return err
+}
+
+func (rs *releaseStream) finish() {
+	rs.stop()
+	rs.release()
+}
@@ -0,0 +1,239 @@
+package hornet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestClientConn_ServerStream(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should receive all messages", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		stream, err := client.Count(ctx, wrapperspb.Int64(3))
+		is.NoErr(err)
+
+		for i := range int64(3) {
+			msg, err := stream.Recv()
+			is.NoErr(err)
+			is.Equal(msg.GetValue(), i)
+		}
+
+		_, err = stream.Recv()
+		is.True(errors.Is(err, io.EOF))
+	})
+
+	t.Run("should receive messages sent after a delay", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// The handler sleeps before sending each message.
+		stream, err := client.Count(ctx, wrapperspb.Int64(-3))
+		is.NoErr(err)
+
+		for i := range int64(3) {
+			msg, err := stream.Recv()
+			is.NoErr(err)
+			is.Equal(msg.GetValue(), i)
+		}
+	})
+
+	t.Run("should wait for header and keep first message", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// The handler sleeps before sending the first message.
+		stream, err := client.Count(ctx, wrapperspb.Int64(-2))
+		is.NoErr(err)
+
+		header, err := stream.Header()
+		is.NoErr(err)
+		is.Equal(header.Get("x-count"), []string{"-2"})
+
+		for i := range int64(2) {
+			msg, err := stream.Recv()
+			is.NoErr(err)
+			is.Equal(msg.GetValue(), i)
+		}
+
+		header, err = stream.Header()
+		is.NoErr(err)
+		is.Equal(header.Get("x-count"), []string{"-2"})
+	})
+
+	t.Run("should return header at end of stream without messages", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		stream, err := client.Count(ctx, wrapperspb.Int64(0))
+		is.NoErr(err)
+
+		header, err := stream.Header()
+		is.NoErr(err)
+		is.Equal(header.Get("x-count"), []string{"0"})
+
+		_, err = stream.Recv()
+		is.True(errors.Is(err, io.EOF))
+	})
+
+	t.Run("should cancel stream while handler waits on its context", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		// The handler sends one message and waits until the stream is
+		// cancelled.
+		stream, err := client.Count(ctx, wrapperspb.Int64(-1))
+		is.NoErr(err)
+
+		_, err = stream.Recv()
+		is.NoErr(err)
+
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		_, err = stream.Recv()
+		is.Equal(status.Code(err), codes.Canceled)
+
+		// The plugin keeps working after the stream was cancelled.
+		resp, err := client.Echo(context.Background(), wrapperspb.String("hello"))
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), "hello")
+	})
+}
+
+func TestClientConn_ClientStream(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should send all messages and receive response", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		stream, err := client.Sum(ctx)
+		is.NoErr(err)
+
+		for i := range int64(5) {
+			is.NoErr(stream.Send(wrapperspb.Int64(i)))
+		}
+
+		resp, err := stream.CloseAndRecv()
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), int64(10))
+	})
+
+	t.Run("should receive response without messages", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		stream, err := client.Sum(ctx)
+		is.NoErr(err)
+
+		resp, err := stream.CloseAndRecv()
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), int64(0))
+	})
+
+	t.Run("should cancel stream while handler waits for input", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		ctx, cancel := context.WithCancel(ctx)
+
+		stream, err := client.Sum(ctx)
+		is.NoErr(err)
+		is.NoErr(stream.Send(wrapperspb.Int64(1)))
+
+		cancel()
+
+		err = stream.Send(wrapperspb.Int64(2))
+		is.True(errors.Is(err, io.EOF))
+
+		_, err = stream.CloseAndRecv()
+		is.Equal(status.Code(err), codes.Canceled)
+	})
+}
+
+func TestClientConn_BidiStream(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should exchange messages", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		stream, err := client.Chat(ctx)
+		is.NoErr(err)
+
+		for _, msg := range []string{"hello", "plugin"} {
+			is.NoErr(stream.Send(wrapperspb.String(msg)))
+
+			resp, err := stream.Recv()
+			is.NoErr(err)
+			is.Equal(resp.GetValue(), strings.ToUpper(msg))
+		}
+
+		is.NoErr(stream.CloseSend())
+
+		_, err = stream.Recv()
+		is.True(errors.Is(err, io.EOF))
+	})
+
+	t.Run("should wait for input in recv until message is sent", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		stream, err := client.Chat(ctx)
+		is.NoErr(err)
+
+		time.AfterFunc(50*time.Millisecond, func() {
+			_ = stream.Send(wrapperspb.String("late"))
+		})
+
+		resp, err := stream.Recv()
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), "LATE")
+
+		is.NoErr(stream.CloseSend())
+	})
+
+	t.Run("should run streams concurrently", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		first, err := client.Chat(ctx)
+		is.NoErr(err)
+		second, err := client.Chat(ctx)
+		is.NoErr(err)
+
+		is.NoErr(first.Send(wrapperspb.String("first")))
+		is.NoErr(second.Send(wrapperspb.String("second")))
+
+		resp, err := second.Recv()
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), "SECOND")
+
+		resp, err = first.Recv()
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), "FIRST")
+
+		is.NoErr(first.CloseSend())
+		is.NoErr(second.CloseSend())
+	})
+}
@@ -0,0 +1,184 @@
+package hornet
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/matryer/is"
+	"github.com/tetratelabs/wazero"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestClientOptions_ApplyModuleConfig(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should apply module options in order", func(t *testing.T) {
+		is := is.New(t)
+
+		r := wazero.NewRuntime(ctx)
+		t.Cleanup(func() { _ = r.Close(ctx) })
+
+		opts := defaultClientOptions
+		for _, o := range []ClientOption{WithModuleName("first"), WithModuleName("second")} {
+			o.applyClient(&opts)
+		}
+
+		_, err := r.InstantiateWithConfig(ctx, minimalMemoryModule, opts.applyModuleConfig(newModuleConfig()))
+		is.NoErr(err)
+
+		is.True(r.Module("first") == nil)
+		is.True(r.Module("second") != nil)
+	})
+}
+
+func TestClientConn_Invoke(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should call plugin", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		resp, err := client.Echo(ctx, wrapperspb.String("hello"))
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), "hello")
+	})
+
+	t.Run("should return error of plugin", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		_, err := client.Echo(ctx, wrapperspb.String("error"))
+		is.Equal(status.Code(err), codes.InvalidArgument)
+		is.Equal(status.Convert(err).Message(), "echo error")
+	})
+
+	t.Run("should propagate deadline to plugin", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		resp, err := client.Echo(ctx, wrapperspb.String("deadline"))
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), "no deadline")
+
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		// The plugin returns once its context is done, the module keeps
+		// working.
+		_, err = client.Echo(ctx, wrapperspb.String("deadline"))
+		is.Equal(status.Code(err), codes.DeadlineExceeded)
+
+		_, err = client.Echo(context.Background(), wrapperspb.String("hello"))
+		is.NoErr(err)
+	})
+
+	t.Run("should close module if plugin exits", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		_, err := client.Echo(ctx, wrapperspb.String("exit"))
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), `Wasm function "hornet-v2-command"`)) // uses the exported name
+
+		_, err = client.Echo(ctx, wrapperspb.String("hello"))
+		is.Equal(status.Code(err), codes.Unavailable)
+	})
+}
+
+func TestClientConn_CloseOnContextDone(t *testing.T) {
+	ctx := context.Background()
+
+	newClient := func(t *testing.T) *testsvc.TestServiceClient {
+		t.Helper()
+		testPluginModule(t)
+
+		return testsvc.NewTestServiceClient(instantiateTestPlugin(t, newTestRuntime(t, true)))
+	}
+
+	t.Run("should send earlier deadline to plugin", func(t *testing.T) {
+		is := is.New(t)
+		client := newClient(t)
+
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+
+		resp, err := client.Echo(ctx, wrapperspb.String("timeout"))
+		is.NoErr(err)
+
+		timeout, err := time.ParseDuration(resp.GetValue())
+		is.NoErr(err)
+		is.True(timeout <= 950*time.Millisecond) // the plugin sees the deadline earlier
+		is.True(timeout > time.Second/2)
+	})
+
+	t.Run("should keep module if plugin returns before deadline", func(t *testing.T) {
+		is := is.New(t)
+		client := newClient(t)
+
+		for range 3 {
+			ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			_, err := client.Echo(ctx, wrapperspb.String("deadline"))
+			cancel()
+
+			is.Equal(status.Code(err), codes.DeadlineExceeded)
+		}
+
+		_, err := client.Echo(ctx, wrapperspb.String("hello"))
+		is.NoErr(err)
+	})
+
+	t.Run("should close module if plugin runs past deadline", func(t *testing.T) {
+		is := is.New(t)
+		client := newClient(t)
+
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		_, err := client.Echo(ctx, wrapperspb.String("loop"))
+		is.Equal(status.Code(err), codes.DeadlineExceeded)
+
+		_, err = client.Echo(context.Background(), wrapperspb.String("hello"))
+		is.Equal(status.Code(err), codes.Unavailable)
+	})
+
+	t.Run("should close module if call is cancelled", func(t *testing.T) {
+		is := is.New(t)
+		client := newClient(t)
+
+		ctx, cancel := context.WithCancel(ctx)
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		_, err := client.Echo(ctx, wrapperspb.String("loop"))
+		is.Equal(status.Code(err), codes.Canceled)
+
+		_, err = client.Echo(context.Background(), wrapperspb.String("hello"))
+		is.Equal(status.Code(err), codes.Unavailable)
+	})
+}
+
+func TestModuleTimeout(t *testing.T) {
+	testCases := []struct {
+		remaining time.Duration
+		margin    time.Duration
+		want      time.Duration
+	}{
+		{remaining: time.Second, margin: 50 * time.Millisecond, want: 950 * time.Millisecond},
+		{remaining: 60 * time.Millisecond, margin: 50 * time.Millisecond, want: 30 * time.Millisecond},
+		{remaining: time.Second, margin: 0, want: time.Second},
+		{remaining: 0, margin: 50 * time.Millisecond, want: 1},
+		{remaining: -time.Second, margin: 50 * time.Millisecond, want: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("should return %v for %v with margin %v", tc.want, tc.remaining, tc.margin), func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(moduleTimeout(tc.remaining, tc.margin), tc.want)
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package hornet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The v2 protocol wraps every request and response in an envelope that
+// consists of a small header followed by the payload.
+//
+// A request envelope is passed to the Wasm module as a single buffer, where
+// the first headerSize bytes contain the protobuf encoded request header and
+// the rest of the buffer contains the payload.
+//
+// A response envelope starts with a single byte containing the response type,
+// followed by the size of the protobuf encoded response header as a 4 byte
+// little endian integer, the response header and the payload.
+//
+// The headers are encoded manually using protowire and correspond to the
+// following protobuf messages. Unknown fields are skipped, so new fields can be
+// added without breaking plugins and hosts built with older versions of
+// Hornet:
+//
+//	message RequestHeader {
+//	  string method = 1;
+//	  repeated MetadataEntry metadata = 2;
+//	  int64 timeout = 3; // in nanoseconds
+//	  uint64 call_id = 4;
+//	  uint32 flags = 5;
+//	}
+//
+//	message ResponseHeader {
+//	  repeated MetadataEntry header = 1;
+//	  repeated MetadataEntry trailer = 2;
+//	}
+//
+//	message MetadataEntry {
+//	  string key = 1;
+//	  repeated bytes values = 2;
+//	}
+
+// responseEnvelopePrefixSize is the size of the response type and the
+// response header size at the start of a response envelope.
+const responseEnvelopePrefixSize = 5
+
+// requestHeader is the header of a request envelope.
+type requestHeader struct {
+	// method is the full method name of the RPC, empty for messages sent to
+	// an open stream.
+	method string
+	// metadata contains the outgoing metadata of the host.
+	metadata metadata.MD
+	// timeout is the time remaining until the deadline of the host context,
+	// zero if the context has no deadline. The timeout is relative, because
+	// the clock of the Wasm module is not necessarily in sync with the host.
+	timeout time.Duration
+	// callID identifies the call on the host, it is included in logs to
+	// correlate them with the host.
+	callID uint64
+	// flags is a bit set of request flags. No flags are defined yet, plugins
+	// ignore unknown flags.
+	flags uint32
+}
+
+func (h *requestHeader) appendTo(b []byte) []byte {
+	if h.method != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, h.method)
+	}
+
+	b = appendMetadata(b, 2, h.metadata)
+
+	if h.timeout > 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.timeout))
+	}
+
+	if h.callID != 0 {
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, h.callID)
+	}
+
+	if h.flags != 0 {
+		b = protowire.AppendTag(b, 5, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.flags))
+	}
+
+	return b
+}
+
+func (h *requestHeader) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			h.method = v
+
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			return consumeMetadataEntry(b, &h.metadata)
+		case num == 3 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			h.timeout = time.Duration(v) //nolint:gosec // the timeout was encoded from a time.Duration
+
+			return n, nil
+		case num == 4 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			h.callID = v
+
+			return n, nil
+		case num == 5 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			h.flags = uint32(v) //nolint:gosec // the flags were encoded from a uint32
+
+			return n, nil
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+}
+
+// responseHeader is the header of a response envelope.
+type responseHeader struct {
+	// header contains the header metadata sent by the plugin.
+	header metadata.MD
+	// trailer contains the trailer metadata sent by the plugin.
+	trailer metadata.MD
+}
+
+func (h *responseHeader) appendTo(b []byte) []byte {
+	b = appendMetadata(b, 1, h.header)
+	b = appendMetadata(b, 2, h.trailer)
+
+	return b
+}
+
+func (h *responseHeader) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			return consumeMetadataEntry(b, &h.header)
+		case num == 2 && typ == protowire.BytesType:
+			return consumeMetadataEntry(b, &h.trailer)
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+}
+
+// appendResponseEnvelope appends the response type and the response header to
+// b. The payload can be appended to the returned slice.
+func appendResponseEnvelope(b []byte, typ byte, h *responseHeader) []byte {
+	b = append(b, typ, 0, 0, 0, 0)
+	start := len(b)
+
+	if h != nil {
+		b = h.appendTo(b)
+	}
+
+	binary.LittleEndian.PutUint32(b[start-4:start], uint32(len(b)-start)) //nolint:gosec // no risk of overflow
+
+	return b
+}
+
+// parseResponseEnvelope splits the response envelope into the response type,
+// the decoded response header and the payload.
+func parseResponseEnvelope(b []byte) (byte, responseHeader, []byte, error) {
+	var h responseHeader
+
+	if len(b) < responseEnvelopePrefixSize {
+		return 0, h, nil, fmt.Errorf("response envelope too short: %d bytes", len(b))
+	}
+
+	typ := b[0]
+	size := binary.LittleEndian.Uint32(b[1:responseEnvelopePrefixSize])
+	b = b[responseEnvelopePrefixSize:]
+
+	if uint64(size) > uint64(len(b)) {
+		return 0, h, nil, fmt.Errorf("response header size %d exceeds envelope size %d", size, len(b))
+	}
+
+	err := h.unmarshal(b[:size])
+	if err != nil {
+		return 0, h, nil, fmt.Errorf("failed to decode response header: %w", err)
+	}
+
+	return typ, h, b[size:], nil
+}
+
+// appendMetadata appends each metadata key as a MetadataEntry message with the
+// given field number.
+func appendMetadata(b []byte, num protowire.Number, md metadata.MD) []byte {
+	for k, vs := range md {
+		size := protowire.SizeTag(1) + protowire.SizeBytes(len(k))
+		for _, v := range vs {
+			size += protowire.SizeTag(2) + protowire.SizeBytes(len(v))
+		}
+
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(size)) //nolint:gosec // no risk of overflow
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, k)
+
+		for _, v := range vs {
+			b = protowire.AppendTag(b, 2, protowire.BytesType)
+			b = protowire.AppendString(b, v)
+		}
+	}
+
+	return b
+}
+
+// consumeMetadataEntry decodes a length-prefixed MetadataEntry message from b
+// and adds it to md. It returns the number of bytes consumed.
+func consumeMetadataEntry(b []byte, md *metadata.MD) (int, error) {
+	entry, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return n, nil
+	}
+
+	var (
+		key    string
+		values []string
+	)
+
+	err := consumeFields(entry, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			key = v
+
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			values = append(values, v)
+
+			return n, nil
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if key == "" {
+		return 0, errors.New("metadata entry without key")
+	}
+
+	if *md == nil {
+		*md = metadata.MD{}
+	}
+
+	(*md)[key] = append((*md)[key], values...)
+
+	return n, nil
+}
+
+// consumeFields calls fn for each field in the protobuf encoded message b. The
+// function receives the bytes following the field tag and returns the number
+// of bytes it consumed, or a negative number if the value is malformed.
+func consumeFields(b []byte, fn func(protowire.Number, protowire.Type, []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		b = b[n:]
+
+		n, err := fn(num, typ, b)
+		if err != nil {
+			return err
+		}
+
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+
+		b = b[n:]
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package hornet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestRequestHeader_RoundTrip(t *testing.T) {
+	t.Run("should encode and decode all fields", func(t *testing.T) {
+		is := is.New(t)
+		want := requestHeader{
+			method:   "/test.Service/Method",
+			metadata: metadata.Pairs("key", "value1", "key", "value2", "other-bin", "\x00\x01"),
+			timeout:  1500 * time.Millisecond,
+			callID:   42,
+			flags:    0b101,
+		}
+
+		var got requestHeader
+		err := got.unmarshal(want.appendTo(nil))
+
+		is.NoErr(err)
+		is.Equal(got.method, want.method)
+		is.Equal(got.metadata, want.metadata)
+		is.Equal(got.timeout, want.timeout)
+		is.Equal(got.callID, want.callID)
+		is.Equal(got.flags, want.flags)
+	})
+
+	t.Run("should encode an empty header to zero bytes", func(t *testing.T) {
+		is := is.New(t)
+		var h requestHeader
+
+		b := h.appendTo(nil)
+		is.Equal(len(b), 0)
+
+		err := h.unmarshal(b)
+		is.NoErr(err)
+		is.Equal(h.method, "")
+		is.Equal(h.metadata, nil)
+		is.Equal(h.timeout, time.Duration(0))
+	})
+
+	t.Run("should skip unknown fields", func(t *testing.T) {
+		is := is.New(t)
+		want := requestHeader{method: "/test.Service/Method", callID: 7}
+
+		b := protowire.AppendTag(nil, 100, protowire.BytesType)
+		b = protowire.AppendString(b, "from the future")
+		b = want.appendTo(b)
+
+		var got requestHeader
+		err := got.unmarshal(b)
+
+		is.NoErr(err)
+		is.Equal(got.method, want.method)
+		is.Equal(got.callID, want.callID)
+	})
+
+	t.Run("should fail to decode malformed header", func(t *testing.T) {
+		is := is.New(t)
+		var h requestHeader
+
+		err := h.unmarshal([]byte{0x0a, 0x05, 'a'}) // method with length 5, but only 1 byte
+		is.True(err != nil)
+	})
+}
+
+func TestResponseEnvelope_RoundTrip(t *testing.T) {
+	t.Run("should encode and decode header, trailer and payload", func(t *testing.T) {
+		is := is.New(t)
+		h := &responseHeader{
+			header:  metadata.Pairs("h", "1"),
+			trailer: metadata.Pairs("t", "2", "t", "3"),
+		}
+
+		b := append(appendResponseEnvelope(nil, responseError, h), "payload"...)
+
+		typ, got, payload, err := parseResponseEnvelope(b)
+		is.NoErr(err)
+		is.Equal(typ, responseError)
+		is.Equal(got.header, h.header)
+		is.Equal(got.trailer, h.trailer)
+		is.Equal(string(payload), "payload")
+	})
+
+	t.Run("should encode and decode envelope without header", func(t *testing.T) {
+		is := is.New(t)
+
+		b := append(appendResponseEnvelope(nil, responseOK, nil), "payload"...)
+		is.Equal(len(b), responseEnvelopePrefixSize+len("payload"))
+
+		typ, got, payload, err := parseResponseEnvelope(b)
+		is.NoErr(err)
+		is.Equal(typ, responseOK)
+		is.Equal(got.header, nil)
+		is.Equal(got.trailer, nil)
+		is.Equal(string(payload), "payload")
+	})
+
+	t.Run("should fail to parse truncated envelope", func(t *testing.T) {
+		is := is.New(t)
+		h := &responseHeader{header: metadata.Pairs("h", "1")}
+		b := appendResponseEnvelope(nil, responseOK, h)
+
+		_, _, _, err := parseResponseEnvelope(b[:len(b)-1])
+		is.True(err != nil)
+
+		_, _, _, err = parseResponseEnvelope(b[:responseEnvelopePrefixSize-1])
+		is.True(err != nil)
+	})
+}
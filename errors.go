@@ -0,0 +1,202 @@
+package hornet
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"sync"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorInfoDomain is the domain of the errdetails.ErrorInfo used to encode
+// registered errors in a status.
+const errorInfoDomain = "hornet"
+
+// errorRegistry contains the errors registered using [RegisterError] and
+// [RegisterErrorType].
+var errorRegistry = struct {
+	mu       sync.RWMutex // guards following fields
+	entries  []*errorEntry
+	byReason map[string]*errorEntry
+}{
+	byReason: make(map[string]*errorEntry),
+}
+
+// errorEntry describes a registered error.
+type errorEntry struct {
+	reason string
+	code   codes.Code
+	// match reports if the error matches the registered error and returns
+	// the metadata describing it.
+	match func(error) (map[string]string, bool)
+	// build reconstructs the error from the metadata.
+	build func(map[string]string) error
+}
+
+// RegisterError registers a sentinel error, so that it can cross the boundary
+// between the plugin and the host. Errors returned by a handler that match err
+// using errors.Is are sent as a status with the given code, and are turned into
+// an error that matches err using errors.Is on the receiving side. The error
+// still carries the status, so status.FromError and status.Code work as well.
+//
+// The reason identifies the error and must be unique. The error needs to be
+// registered with the same reason on both sides, typically in an init
+// function of a package shared by the host and the plugin. RegisterError
+// panics if the reason is already registered.
+func RegisterError(reason string, code codes.Code, err error) {
+	registerError(&errorEntry{
+		reason: reason,
+		code:   code,
+		match: func(e error) (map[string]string, bool) {
+			return nil, errors.Is(e, err)
+		},
+		build: func(map[string]string) error {
+			return err
+		},
+	})
+}
+
+// RegisterErrorType registers a structured error type T, so that it can cross
+// the boundary between the plugin and the host. Errors returned by a handler
+// that match T using errors.As are encoded using marshal and sent as a status
+// with the given code. On the receiving side, the error is reconstructed using
+// unmarshal and can be retrieved using errors.As.
+//
+// The reason identifies the error type and must be unique. The error type
+// needs to be registered with the same reason on both sides, see
+// [RegisterError]. RegisterErrorType panics if the reason is already
+// registered.
+func RegisterErrorType[T error](
+	reason string,
+	code codes.Code,
+	marshal func(T) map[string]string,
+	unmarshal func(map[string]string) T,
+) {
+	registerError(&errorEntry{
+		reason: reason,
+		code:   code,
+		match: func(e error) (map[string]string, bool) {
+			var target T
+			if !errors.As(e, &target) {
+				return nil, false
+			}
+
+			return marshal(target), true
+		},
+		build: func(md map[string]string) error {
+			return unmarshal(md)
+		},
+	})
+}
+
+func registerError(e *errorEntry) {
+	errorRegistry.mu.Lock()
+	defer errorRegistry.mu.Unlock()
+
+	if _, ok := errorRegistry.byReason[e.reason]; ok {
+		panic(fmt.Sprintf("hornet: error with reason %q is already registered", e.reason))
+	}
+
+	errorRegistry.entries = append(errorRegistry.entries, e)
+	errorRegistry.byReason[e.reason] = e
+}
+
+// statusFromError converts an error returned by a handler into a status. If
+// the error matches a registered error, the status contains an
+// errdetails.ErrorInfo describing it. Context errors are converted to the
+// corresponding codes, other errors that are not a status have the code
+// Unknown.
+func statusFromError(err error) *status.Status {
+	if st, ok := registeredErrorStatus(err); ok {
+		return st
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		st = status.FromContextError(err)
+	}
+
+	return st
+}
+
+// panicError converts a value recovered from a panic in a handler into an
+// error with the code Internal. The status contains an errdetails.DebugInfo
+// with the panic message and the stack trace of the panicking goroutine, so it
+// must be called in the deferred function that recovered the panic.
+func panicError(v any) error {
+	st := status.Newf(codes.Internal, "panic in handler: %v", v)
+
+	stWithDetails, err := st.WithDetails(&errdetails.DebugInfo{
+		StackEntries: strings.Split(strings.TrimSpace(string(debug.Stack())), "\n"),
+		Detail:       fmt.Sprint(v),
+	})
+	if err != nil {
+		// This should never happen, as DebugInfo can always be marshalled.
+		return st.Err() //nolint:wrapcheck // The status is the error.
+	}
+
+	return stWithDetails.Err() //nolint:wrapcheck // The status is the error.
+}
+
+func registeredErrorStatus(err error) (*status.Status, bool) {
+	errorRegistry.mu.RLock()
+	defer errorRegistry.mu.RUnlock()
+
+	for _, e := range errorRegistry.entries {
+		md, ok := e.match(err)
+		if !ok {
+			continue
+		}
+
+		st, detailsErr := status.New(e.code, err.Error()).WithDetails(&errdetails.ErrorInfo{
+			Reason:   e.reason,
+			Domain:   errorInfoDomain,
+			Metadata: md,
+		})
+		if detailsErr != nil {
+			// This should never happen, as ErrorInfo can always be marshalled.
+			return nil, false
+		}
+
+		return st, true
+	}
+
+	return nil, false
+}
+
+// errorFromStatus converts a status received from the other side into an
+// error. If the status describes a registered error, the returned error wraps
+// the reconstructed error.
+func errorFromStatus(st *status.Status) error {
+	for _, d := range st.Details() {
+		info, ok := d.(*errdetails.ErrorInfo)
+		if !ok || info.GetDomain() != errorInfoDomain {
+			continue
+		}
+
+		errorRegistry.mu.RLock()
+		e, ok := errorRegistry.byReason[info.GetReason()]
+		errorRegistry.mu.RUnlock()
+
+		if ok {
+			return &registeredError{st: st, err: e.build(info.GetMetadata())}
+		}
+	}
+
+	return st.Err() //nolint:wrapcheck // We want to preserve the original error.
+}
+
+// registeredError is a registered error received from the other side. It
+// unwraps to the reconstructed error and carries the original status.
+type registeredError struct {
+	st  *status.Status
+	err error
+}
+
+func (e *registeredError) Error() string              { return e.st.Message() }
+func (e *registeredError) Unwrap() error              { return e.err }
+func (e *registeredError) GRPCStatus() *status.Status { return e.st }
@@ -0,0 +1,156 @@
+package hornet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/matryer/is"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var errTestSentinel = errors.New("test sentinel")
+
+type testStructuredError struct {
+	Field string
+	Limit int
+}
+
+func (e *testStructuredError) Error() string {
+	return fmt.Sprintf("field %s exceeds limit %d", e.Field, e.Limit)
+}
+
+func init() {
+	RegisterError("hornet.test.Sentinel", codes.FailedPrecondition, errTestSentinel)
+	RegisterErrorType(
+		"hornet.test.Structured",
+		codes.InvalidArgument,
+		func(e *testStructuredError) map[string]string {
+			return map[string]string{"field": e.Field, "limit": strconv.Itoa(e.Limit)}
+		},
+		func(md map[string]string) *testStructuredError {
+			limit, _ := strconv.Atoi(md["limit"])
+			return &testStructuredError{Field: md["field"], Limit: limit}
+		},
+	)
+
+	// The errors returned by the test plugin.
+	RegisterError(testsvc.SentinelErrorReason, codes.FailedPrecondition, testsvc.ErrSentinel)
+	RegisterErrorType(
+		testsvc.LimitErrorReason, codes.InvalidArgument,
+		testsvc.MarshalLimitError, testsvc.UnmarshalLimitError,
+	)
+}
+
+// roundTripError simulates sending the error returned by a handler across the
+// boundary.
+func roundTripError(t *testing.T, err error) error {
+	t.Helper()
+	is := is.New(t)
+
+	b, marshalErr := proto.Marshal(statusFromError(err).Proto())
+	is.NoErr(marshalErr)
+
+	var st spb.Status
+	is.NoErr(proto.Unmarshal(b, &st))
+
+	return errorFromStatus(status.FromProto(&st))
+}
+
+func TestErrorRegistry_RoundTrip(t *testing.T) {
+	t.Run("should reconstruct wrapped sentinel error", func(t *testing.T) {
+		is := is.New(t)
+
+		got := roundTripError(t, fmt.Errorf("operation failed: %w", errTestSentinel))
+
+		is.True(errors.Is(got, errTestSentinel))
+		is.Equal(status.Code(got), codes.FailedPrecondition)
+		is.Equal(got.Error(), "operation failed: test sentinel")
+	})
+
+	t.Run("should reconstruct structured error", func(t *testing.T) {
+		is := is.New(t)
+
+		got := roundTripError(t, &testStructuredError{Field: "name", Limit: 10})
+
+		var target *testStructuredError
+		is.True(errors.As(got, &target))
+		is.Equal(*target, testStructuredError{Field: "name", Limit: 10})
+		is.Equal(status.Code(got), codes.InvalidArgument)
+	})
+
+	t.Run("should keep status of unregistered error", func(t *testing.T) {
+		is := is.New(t)
+
+		got := roundTripError(t, status.Error(codes.NotFound, "missing"))
+
+		is.True(!errors.Is(got, errTestSentinel))
+		is.Equal(status.Code(got), codes.NotFound)
+		is.Equal(status.Convert(got).Message(), "missing")
+	})
+
+	t.Run("should panic on duplicate reason", func(t *testing.T) {
+		is := is.New(t)
+
+		defer func() {
+			is.True(recover() != nil)
+		}()
+
+		RegisterError("hornet.test.Sentinel", codes.Internal, errors.New("other"))
+	})
+}
+
+func TestErrorRegistry_Plugin(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should reconstruct sentinel error returned by plugin", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		_, err := client.Echo(ctx, wrapperspb.String("sentinel"))
+		is.True(errors.Is(err, testsvc.ErrSentinel))
+		is.Equal(status.Code(err), codes.FailedPrecondition)
+		is.Equal(err.Error(), "echo failed: test sentinel")
+	})
+
+	t.Run("should reconstruct structured error returned by plugin", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		_, err := client.Echo(ctx, wrapperspb.String("limit"))
+
+		var target *testsvc.LimitError
+		is.True(errors.As(err, &target))
+		is.Equal(*target, testsvc.LimitError{Field: "value", Limit: 10})
+		is.Equal(status.Code(err), codes.InvalidArgument)
+	})
+
+	t.Run("should return panic in plugin with debug info", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		_, err := client.Echo(ctx, wrapperspb.String("panic"))
+		st := status.Convert(err)
+		is.Equal(st.Code(), codes.Internal)
+		is.Equal(st.Message(), "panic in handler: echo panic")
+
+		is.Equal(len(st.Details()), 1)
+		info, ok := st.Details()[0].(*errdetails.DebugInfo)
+		is.True(ok)
+		is.Equal(info.GetDetail(), "echo panic")
+		is.True(len(info.GetStackEntries()) > 0)
+
+		// The plugin keeps working after the panic.
+		resp, err := client.Echo(ctx, wrapperspb.String("hello"))
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), "hello")
+	})
+}
@@ -11,9 +11,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/lovromazgon/hornet"
 	"github.com/lovromazgon/hornet/examples/calculator/sdk"
+	calculatorv1 "github.com/lovromazgon/hornet/examples/calculator/sdk/proto/calculator/v1"
 	"github.com/tetratelabs/wazero"
-	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
 )
 
 const pluginPath = "../plugin/main.wasm"
@@ -91,41 +92,60 @@ func main() {
 }
 
 func initPlugin(ctx context.Context, path string) (sdk.Calculator, func(), error) {
-	// Create a Wasm runtime, set up WASI.
-	r := wazero.NewRuntime(ctx)
-
-	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+	// Cache compiled modules on disk, so that only the first run needs to
+	// compile the plugin.
+	cache, err := hornet.NewCompilationCache("")
+	if err != nil {
+		return nil, nil, err
+	}
 
-	wasmBytes, err := os.ReadFile(path)
+	// Create a manager, it sets up the Wasm runtime with WASI and the host
+	// module. Closing modules when the context is done interrupts plugin calls
+	// that run past their deadline, which closes the plugin. The plugin sees
+	// the deadline a bit earlier than the host (see hornet.WithDeadlineMargin),
+	// so handlers that honor their context return before that happens.
+	m, err := hornet.NewManager(ctx, hornet.WithManagerRuntimeConfig(
+		wazero.NewRuntimeConfig().WithCloseOnContextDone(true).WithCompilationCache(cache),
+	))
 	if err != nil {
-		r.Close(ctx)
-		return nil, nil, fmt.Errorf("failed to read Wasm file %q: %w", path, err)
+		cache.Close(ctx)
+		return nil, nil, fmt.Errorf("failed to create plugin manager: %w", err)
 	}
 
 	initStop, initDone := initProgress()
 
-	module, calc, err := sdk.InitializeModuleAndCalculator(ctx, r, wasmBytes)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to instantiate Wasm module: %w", err)
-	}
+	_, err = m.LoadFile(ctx, path)
 
 	close(initStop)
 	<-initDone
 
+	if err != nil {
+		m.Close(ctx)
+		cache.Close(ctx)
+		return nil, nil, fmt.Errorf("failed to load plugin: %w", err)
+	}
+
+	// The plugin is named after its file.
+	client, err := hornet.PluginClient(m, "main", calculatorv1.NewCalculatorPluginClient)
+	if err != nil {
+		m.Close(ctx)
+		cache.Close(ctx)
+		return nil, nil, err
+	}
+
 	closeFn := func() {
-		fmt.Println("Closing Wasm module...")
-		err := module.Close(ctx)
+		fmt.Println("Closing plugins and Wasm runtime...")
+		err := m.Close(ctx)
 		if err != nil {
-			fmt.Printf("Error closing module: %v\n", err)
+			fmt.Printf("Error closing plugin manager: %v\n", err)
 		}
-		fmt.Println("Closing Wasm runtime...")
-		err = r.Close(ctx)
+		err = cache.Close(ctx)
 		if err != nil {
-			fmt.Printf("Error closing runtime: %v\n", err)
+			fmt.Printf("Error closing compilation cache: %v\n", err)
 		}
 	}
 
-	return calc, closeFn, nil
+	return sdk.NewCalculatorFromClient(client), closeFn, nil
 }
 
 func next(scanner *bufio.Scanner) (operation, int64, int64, error) {
@@ -2,7 +2,6 @@ package sdk
 
 import (
 	"context"
-	"errors"
 	"fmt"
 
 	"github.com/lovromazgon/hornet"
@@ -10,8 +9,6 @@ import (
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 // calculatorClient is an adapter that wraps a calculatorv1.CalculatorPluginClient
@@ -52,13 +49,6 @@ func (c *calculatorClient) Mul(ctx context.Context, a, b int64) (int64, error) {
 func (c *calculatorClient) Div(ctx context.Context, a, b int64) (int64, error) {
 	out, err := c.client.Div(ctx, &calculatorv1.DivRequest{A: a, B: b})
 	if err != nil {
-		// Check if the error is a gRPC error with code InvalidArgument,
-		// which indicates a division by zero attempt.
-		// If so, return the predefined ErrDivisionByZero error.
-		st, ok := status.FromError(err)
-		if ok && st.Code() == codes.InvalidArgument {
-			return 0, ErrDivisionByZero
-		}
 		return 0, err
 	}
 
@@ -105,12 +95,6 @@ func (c *calculatorServer) Mul(ctx context.Context, req *calculatorv1.MulRequest
 func (c *calculatorServer) Div(ctx context.Context, req *calculatorv1.DivRequest) (*calculatorv1.DivResponse, error) {
 	out, err := c.impl.Div(ctx, req.GetA(), req.GetB())
 	if err != nil {
-		if errors.Is(err, ErrDivisionByZero) {
-			// Return a gRPC InvalidArgument error if division by zero is attempted.
-			// The client can then check for this specific error and handle it
-			// accordingly.
-			return nil, status.Error(codes.InvalidArgument, err.Error())
-		}
 		return nil, err
 	}
 
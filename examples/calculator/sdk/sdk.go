@@ -3,11 +3,20 @@ package sdk
 import (
 	"context"
 	"errors"
+
+	"github.com/lovromazgon/hornet"
+	"google.golang.org/grpc/codes"
 )
 
 // ErrDivisionByZero should be returned when attempting to divide by zero.
 var ErrDivisionByZero = errors.New("division by zero")
 
+func init() {
+	// Register the error on both sides, so that errors.Is works on the host
+	// for errors returned by the plugin.
+	hornet.RegisterError("calculator.DivisionByZero", codes.InvalidArgument, ErrDivisionByZero)
+}
+
 // Calculator is the interface for the plugin.
 //
 // Note that it's advisable for all methods to take a context as a parameter and
@@ -13,7 +13,48 @@ type functionDefinition struct {
 	resultTypes []api.ValueType // result types
 }
 
+// Protocol versions supported by Hornet. The Wasm module reports the highest
+// version it supports using hornet-version, the host uses the highest version
+// supported by both sides. Modules that don't export hornet-version only
+// support protocolV1.
+const (
+	// protocolV1 uses hornet-v1-command, where the request consists of the
+	// method name and the request payload, and the response consists of the
+	// response type and the response payload.
+	protocolV1 uint32 = iota + 1
+	// protocolV2 uses hornet-v2-command and the stream functions, where
+	// requests and responses are wrapped in envelopes, see
+	// appendResponseEnvelope.
+	protocolV2
+
+	// protocolVersion is the highest protocol version supported by this
+	// version of Hornet.
+	protocolVersion = protocolV2
+)
+
+// feature is a bit set of optional features supported by the plugin handler
+// in the Wasm module, reported using hornet-features.
+type feature uint64
+
+const (
+	// featureStreams means the plugin handler supports streaming RPCs.
+	featureStreams feature = 1 << iota
+	// featureMetadata means the plugin handler receives the metadata and the
+	// deadline sent by the host, and returns header and trailer metadata.
+	featureMetadata
+)
+
 var (
+	versionFunctionDefinition = functionDefinition{
+		name:        "hornet-version",
+		paramTypes:  []api.ValueType{},
+		resultTypes: []api.ValueType{api.ValueTypeI32}, // u32 (highest supported protocol version)
+	}
+	featuresFunctionDefinition = functionDefinition{
+		name:        "hornet-features",
+		paramTypes:  []api.ValueType{},
+		resultTypes: []api.ValueType{api.ValueTypeI64}, // u64 (bit set of supported features)
+	}
 	mallocFunctionDefinition = functionDefinition{
 		name: "hornet-v1-malloc",
 		paramTypes: []api.ValueType{
@@ -30,6 +71,76 @@ var (
 		},
 		resultTypes: []api.ValueType{api.ValueTypeI64}, // u64 (pointer and size of the buffer packed in a single u64)
 	}
+	commandV2FunctionDefinition = functionDefinition{
+		name: "hornet-v2-command",
+		paramTypes: []api.ValueType{
+			api.ValueTypeI32, // u32 (pointer to the buffer)
+			api.ValueTypeI32, // u32 (header size)
+			api.ValueTypeI32, // u32 (buffer size)
+		},
+		resultTypes: []api.ValueType{api.ValueTypeI64}, // u64 (pointer and size of the buffer packed in a single u64)
+	}
+	streamOpenFunctionDefinition = functionDefinition{
+		name: "hornet-v2-stream-open",
+		paramTypes: []api.ValueType{
+			api.ValueTypeI32, // u32 (pointer to the buffer)
+			api.ValueTypeI32, // u32 (header size)
+			api.ValueTypeI32, // u32 (buffer size)
+		},
+		resultTypes: []api.ValueType{api.ValueTypeI64}, // u64 (pointer and size of the buffer packed in a single u64)
+	}
+	streamSendFunctionDefinition = functionDefinition{
+		name: "hornet-v2-stream-send",
+		paramTypes: []api.ValueType{
+			api.ValueTypeI32, // u32 (stream ID)
+			api.ValueTypeI32, // u32 (pointer to the buffer)
+			api.ValueTypeI32, // u32 (buffer size)
+		},
+		resultTypes: []api.ValueType{api.ValueTypeI64}, // u64 (pointer and size of the buffer packed in a single u64)
+	}
+	streamCloseSendFunctionDefinition = functionDefinition{
+		name: "hornet-v2-stream-close-send",
+		paramTypes: []api.ValueType{
+			api.ValueTypeI32, // u32 (stream ID)
+		},
+		resultTypes: []api.ValueType{},
+	}
+	streamRecvFunctionDefinition = functionDefinition{
+		name: "hornet-v2-stream-recv",
+		paramTypes: []api.ValueType{
+			api.ValueTypeI32, // u32 (stream ID)
+		},
+		resultTypes: []api.ValueType{api.ValueTypeI64}, // u64 (pointer and size of the buffer packed in a single u64)
+	}
+	streamCloseFunctionDefinition = functionDefinition{
+		name: "hornet-v2-stream-close",
+		paramTypes: []api.ValueType{
+			api.ValueTypeI32, // u32 (stream ID)
+		},
+		resultTypes: []api.ValueType{},
+	}
+)
+
+// The first byte of every response returned by the Wasm module tells the host
+// how to interpret the rest of the response. In the v2 protocol, the payload
+// follows the response header, see appendResponseEnvelope.
+const (
+	// responseOK means the payload is the protobuf encoded response message.
+	responseOK byte = iota
+	// responseError means the payload is the protobuf encoded
+	// google.rpc.Status message.
+	responseError
+	// responseEOF means the stream has no more messages. The payload is
+	// empty.
+	responseEOF
+	// responseNeedInput means the stream handler is waiting for the next
+	// message from the host and can't make progress until it's sent or the
+	// request stream is closed. The payload is empty.
+	responseNeedInput
+	// responsePending means the stream handler did not send a message yet, as
+	// it is waiting on something other than the host, e.g. a timer or its
+	// context. The host should try again later. The payload is empty.
+	responsePending
 )
 
 // getExportedFunction retrieves an exported function from the given module
@@ -53,6 +164,10 @@ func getExportedFunction(module api.Module, wantFn functionDefinition) (fn api.F
 		def = fn.Definition()
 	}()
 
+	if err != nil {
+		return nil, err
+	}
+
 	if !isValidFunctionDefinition(wantFn, def) {
 		return nil, newFunctionDefinitionError(wantFn, def.ParamTypes(), def.ResultTypes())
 	}
@@ -60,6 +175,30 @@ func getExportedFunction(module api.Module, wantFn functionDefinition) (fn api.F
 	return fn, nil
 }
 
+// getOptionalExportedFunction works like getExportedFunction, except that it
+// returns a nil function and no error if the module does not export a function
+// with the expected name. This is used for functions that were added in later
+// versions of Hornet, so that older plugins continue to work.
+func getOptionalExportedFunction(module api.Module, wantFn functionDefinition) (api.Function, error) {
+	if module.ExportedFunction(wantFn.name) == nil {
+		return nil, nil //nolint:nilnil // A missing optional function is not an error.
+	}
+
+	return getExportedFunction(module, wantFn)
+}
+
+// exportedFunctionName returns the name under which the function is exported
+// by the module, e.g. hornet-v2-command, as the name in the function
+// definition is the internal name of the function in the module.
+func exportedFunctionName(fn api.Function) string {
+	def := fn.Definition()
+	if names := def.ExportNames(); len(names) > 0 {
+		return names[0]
+	}
+
+	return def.Name()
+}
+
 func isValidFunctionDefinition(want functionDefinition, got api.FunctionDefinition) bool {
 	if len(got.ParamTypes()) != len(want.paramTypes) ||
 		len(got.ResultTypes()) != len(want.resultTypes) {
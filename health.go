@@ -0,0 +1,138 @@
+package hornet
+
+import (
+	"context"
+	"maps"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+type healthOptions struct {
+	interval time.Duration
+	timeout  time.Duration
+	service  string
+}
+
+func defaultHealthOptions() healthOptions {
+	return healthOptions{
+		interval: 10 * time.Second,
+		timeout:  time.Second,
+	}
+}
+
+// HealthFunc is called by [WatchHealth] when the health of a plugin changes.
+// If the health check failed, status is UNKNOWN and err contains the error,
+// e.g. an error with the code Unavailable if the plugin's module is closed, or
+// Unimplemented if the plugin doesn't implement the health service.
+type HealthFunc func(name string, status healthpb.HealthCheckResponse_ServingStatus, err error)
+
+// WatchHealth periodically calls Check of the standard gRPC health service on
+// each of the given connections, keyed by name, and calls fn with the result
+// of the first check and whenever the result changes. WatchHealth blocks until
+// ctx is done.
+//
+// Note that a check exceeding its timeout (see [WithHealthTimeout]) closes the
+// module if the runtime was created with
+// wazero.RuntimeConfig.WithCloseOnContextDone, the same as any other call.
+func WatchHealth(ctx context.Context, conns map[string]grpc.ClientConnInterface, fn HealthFunc, opt ...HealthOption) {
+	watchHealth(ctx, func() map[string]grpc.ClientConnInterface { return conns }, fn, opt)
+}
+
+// WatchHealth is like the package-level [WatchHealth] function, for a plugin
+// loaded by the manager. It checks every plugin loaded by the manager,
+// including plugins loaded after WatchHealth is called. It returns when ctx is
+// done or the manager is closed.
+func (m *Manager) WatchHealth(ctx context.Context, fn HealthFunc, opt ...HealthOption) {
+	watchHealth(ctx, func() map[string]grpc.ClientConnInterface {
+		if m.isClosed() {
+			return nil
+		}
+
+		conns := make(map[string]grpc.ClientConnInterface)
+		for _, p := range m.Plugins() {
+			conns[p.name] = p.conn
+		}
+
+		return conns
+	}, fn, opt)
+}
+
+// healthResult is the result of a health check.
+type healthResult struct {
+	status healthpb.HealthCheckResponse_ServingStatus
+	code   codes.Code
+}
+
+// watchHealth checks the connections returned by conns until ctx is done or
+// conns returns nil.
+func watchHealth(
+	ctx context.Context,
+	conns func() map[string]grpc.ClientConnInterface,
+	fn HealthFunc,
+	opt []HealthOption,
+) {
+	opts := defaultHealthOptions()
+	for _, o := range opt {
+		o.applyHealth(&opts)
+	}
+
+	last := make(map[string]healthResult)
+
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+
+	for {
+		current := conns()
+		if current == nil {
+			return
+		}
+
+		for name, conn := range current {
+			st, err := checkHealth(ctx, conn, opts)
+			if ctx.Err() != nil {
+				return
+			}
+
+			result := healthResult{status: st, code: status.Code(err)}
+			if prev, ok := last[name]; ok && prev == result {
+				continue
+			}
+
+			last[name] = result
+			fn(name, st, err)
+		}
+
+		// Forget connections that are gone, so they are reported again if
+		// they come back.
+		maps.DeleteFunc(last, func(name string, _ healthResult) bool {
+			_, ok := current[name]
+			return !ok
+		})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkHealth(
+	ctx context.Context,
+	conn grpc.ClientConnInterface,
+	opts healthOptions,
+) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: opts.service})
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN, err
+	}
+
+	return resp.GetStatus(), nil
+}
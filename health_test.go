@@ -0,0 +1,137 @@
+package hornet
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/matryer/is"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// testHealthConn is a connection whose health service reports the status
+// stored in it. The status -1 makes the check fail.
+type testHealthConn struct {
+	grpc.ClientConnInterface
+
+	status atomic.Int32
+}
+
+func (c *testHealthConn) Invoke(_ context.Context, _ string, _, reply any, _ ...grpc.CallOption) error {
+	st := c.status.Load()
+	if st < 0 {
+		return errModuleClosed
+	}
+
+	resp := reply.(*healthpb.HealthCheckResponse)
+	resp.Status = healthpb.HealthCheckResponse_ServingStatus(st)
+
+	return nil
+}
+
+func TestWatchHealth(t *testing.T) {
+	is := is.New(t)
+
+	conn := &testHealthConn{}
+	conn.status.Store(int32(healthpb.HealthCheckResponse_SERVING))
+
+	type event struct {
+		status healthpb.HealthCheckResponse_ServingStatus
+		code   codes.Code
+	}
+
+	events := make(chan event, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		WatchHealth(ctx, map[string]grpc.ClientConnInterface{"plugin": conn},
+			func(name string, st healthpb.HealthCheckResponse_ServingStatus, err error) {
+				is.Equal(name, "plugin")
+				events <- event{status: st, code: status.Code(err)}
+			},
+			WithHealthInterval(time.Millisecond),
+		)
+	}()
+
+	is.Equal(<-events, event{status: healthpb.HealthCheckResponse_SERVING, code: codes.OK})
+
+	conn.status.Store(int32(healthpb.HealthCheckResponse_NOT_SERVING))
+	is.Equal(<-events, event{status: healthpb.HealthCheckResponse_NOT_SERVING, code: codes.OK})
+
+	conn.status.Store(-1)
+	is.Equal(<-events, event{status: healthpb.HealthCheckResponse_UNKNOWN, code: codes.Unavailable})
+
+	cancel()
+	<-done
+
+	// Unchanged results are not reported.
+	is.Equal(len(events), 0)
+}
+
+func TestServer_Health(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should only register health service if requested", func(t *testing.T) {
+		is := is.New(t)
+
+		_, ok := NewServer().GetServiceInfo()[healthpb.Health_ServiceDesc.ServiceName]
+		is.True(!ok)
+
+		_, ok = NewServer(WithHealth()).GetServiceInfo()[healthpb.Health_ServiceDesc.ServiceName]
+		is.True(ok)
+	})
+
+	t.Run("should replace built-in health service with plugin's", func(t *testing.T) {
+		is := is.New(t)
+
+		srv := NewServer(WithHealth())
+		impl := health.NewServer()
+		healthpb.RegisterHealthServer(srv, impl)
+
+		is.Equal(srv.services[healthpb.Health_ServiceDesc.ServiceName].serviceImpl, impl)
+	})
+
+	t.Run("should watch serving status of plugin", func(t *testing.T) {
+		is := is.New(t)
+		testPluginModule(t)
+
+		conn := instantiateTestPlugin(t, newTestRuntime(t, false))
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stream, err := healthpb.NewHealthClient(conn).Watch(ctx, &healthpb.HealthCheckRequest{})
+		is.NoErr(err)
+
+		resp, err := stream.Recv()
+		is.NoErr(err)
+		is.Equal(resp.GetStatus(), healthpb.HealthCheckResponse_SERVING)
+
+		_, err = testsvc.NewTestServiceClient(conn).Echo(ctx, wrapperspb.String("unhealthy"))
+		is.NoErr(err)
+
+		resp, err = stream.Recv()
+		is.NoErr(err)
+		is.Equal(resp.GetStatus(), healthpb.HealthCheckResponse_NOT_SERVING)
+
+		cancel()
+
+		_, err = stream.Recv()
+		is.Equal(status.Code(err), codes.Canceled)
+
+		// The plugin keeps working after the stream is cancelled.
+		resp, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		is.NoErr(err)
+		is.Equal(resp.GetStatus(), healthpb.HealthCheckResponse_NOT_SERVING)
+	})
+}
@@ -0,0 +1,149 @@
+//go:build wasm
+
+package hornet
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// hostCommand calls a host service. It receives a pointer to a memory buffer
+// that contains the request envelope, consisting of the request header of
+// length headerSize followed by the request payload. It returns the size of
+// the response envelope, which needs to be fetched using hostResponse, or 0 if
+// the host can't be called right now.
+//
+//go:wasmimport hornet hornet-v2-host-command
+func hostCommand(ptr uintptr, headerSize, bufferSize uint32) uint32
+
+// hostResponse copies the response of the last host command into the memory
+// buffer at the given pointer. It returns 0 if the response could not be
+// copied.
+//
+//go:wasmimport hornet hornet-v2-host-response
+func hostResponse(ptr uintptr) uint32
+
+var _ grpc.ClientConnInterface = (*HostConn)(nil)
+
+// HostConn represents a virtual connection from the Wasm plugin to the host, to
+// perform RPCs on services the host exposes to the plugin. It can be passed to
+// gRPC client constructors generated by protoc-gen-go-grpc.
+//
+// Host services can only be called while the plugin is handling a call from
+// the host, e.g. in the implementation of an RPC. The host needs to register
+// the services using [WithHostServer].
+type HostConn struct {
+	// m guards calls to the host.
+	m sync.Mutex
+	// buf is the buffer used to send requests to the host.
+	buf buffer
+	// respBuf is the buffer used to receive responses from the host.
+	respBuf buffer
+}
+
+// NewHostConn creates a new connection to the host. The returned connection is
+// safe for concurrent use by multiple goroutines.
+func NewHostConn() *HostConn {
+	return &HostConn{}
+}
+
+// NewStream is not supported, host services support unary RPCs only.
+//
+//nolint:lll // This method is just a stub to satisfy the grpc.ClientConnInterface interface.
+func (c *HostConn) NewStream(context.Context, *grpc.StreamDesc, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+	return nil, status.Error(codes.Unimplemented, "streams are not supported by host services")
+}
+
+// Invoke performs a unary RPC on a host service and returns after the
+// response is received into resp. This method is not meant to be called
+// directly, instead, use the generated client code from protoc-gen-go-grpc to
+// make RPCs.
+//
+// The outgoing metadata and the deadline of ctx are sent to the host. The call
+// options grpc.Header, grpc.Trailer, grpc.Peer, grpc.MaxCallSendMsgSize,
+// grpc.MaxCallRecvMsgSize and grpc.OnFinish are supported, other options fail
+// the call with the code Unimplemented, the same way as in [ClientConn.Invoke].
+func (c *HostConn) Invoke(
+	ctx context.Context,
+	method string,
+	req, resp any,
+	opts ...grpc.CallOption,
+) (err error) {
+	reqMsg, ok := req.(proto.Message)
+	if !ok {
+		return fmt.Errorf("invalid request type: expected proto.Message, got %T", req)
+	}
+
+	respMsg, ok := resp.(proto.Message)
+	if !ok {
+		return fmt.Errorf("invalid response type: expected proto.Message, got %T", req)
+	}
+
+	ci, err := newCallInfo(opts, math.MaxInt, math.MaxInt)
+	if err != nil {
+		return err
+	}
+
+	ci.setPeer(HostModuleName)
+	defer func() { ci.finish(err) }()
+
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+
+	if err := ci.checkSendMsgSize(reqMsg); err != nil {
+		return err
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	hdr := requestHeader{method: method}
+	hdr.metadata, _ = metadata.FromOutgoingContext(ctx)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		// Make sure the timeout is not zero, as that means no deadline.
+		hdr.timeout = max(time.Until(deadline), 1)
+	}
+
+	buf := hdr.appendTo(c.buf[:0])
+	headerSize := len(buf)
+
+	buf, err = proto.MarshalOptions{}.MarshalAppend(buf, reqMsg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal protobuf command request: %w", err)
+	}
+
+	c.buf = buf
+
+	size := hostCommand(c.buf.Pointer(), uint32(headerSize), uint32(len(c.buf))) //nolint:gosec // no risk of overflow
+	if size == 0 {
+		return status.Error(codes.Unavailable, "host services can only be called while handling a call from the host")
+	}
+
+	c.respBuf.Grow(int(size))
+	c.respBuf = c.respBuf[:size]
+
+	if hostResponse(c.respBuf.Pointer()) == 0 {
+		return status.Error(codes.Internal, "failed to receive response from host")
+	}
+
+	typ, rh, payload, err := parseResponseEnvelope(c.respBuf)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to parse response from host: %v", err)
+	}
+
+	ci.setHeader(rh.header)
+	ci.setTrailer(rh.trailer)
+
+	return decodeResponse(typ, payload, respMsg, ci.maxRecvMsgSize)
+}
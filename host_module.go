@@ -0,0 +1,227 @@
+package hornet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HostModuleName is the name of the host module that exposes host functions
+// to Wasm plugins.
+const HostModuleName = "hornet"
+
+var (
+	hostCommandFunctionDefinition = functionDefinition{
+		name: "hornet-v2-host-command",
+		paramTypes: []api.ValueType{
+			api.ValueTypeI32, // u32 (pointer to the buffer)
+			api.ValueTypeI32, // u32 (header size)
+			api.ValueTypeI32, // u32 (buffer size)
+		},
+		resultTypes: []api.ValueType{api.ValueTypeI32}, // u32 (size of the response)
+	}
+	hostResponseFunctionDefinition = functionDefinition{
+		name: "hornet-v2-host-response",
+		paramTypes: []api.ValueType{
+			api.ValueTypeI32, // u32 (pointer to the buffer)
+		},
+		resultTypes: []api.ValueType{api.ValueTypeI32}, // u32 (1 if the response was copied, 0 otherwise)
+	}
+	hostLogFunctionDefinition = functionDefinition{
+		name: "hornet-v1-host-log",
+		paramTypes: []api.ValueType{
+			api.ValueTypeI32, // u32 (pointer to the buffer)
+			api.ValueTypeI32, // u32 (buffer size)
+		},
+		resultTypes: []api.ValueType{},
+	}
+)
+
+// hostModuleMu serializes the instantiation of host modules, so that plugins
+// instantiated concurrently, e.g. by a Pool, don't try to instantiate the host
+// module in the same runtime twice.
+var hostModuleMu sync.Mutex
+
+// InstantiateHostModule instantiates the host module that exposes host
+// functions to Wasm plugins in the given runtime. If the runtime already
+// contains a module named [HostModuleName], that module is returned.
+//
+// The host module needs to be instantiated before any plugin that calls host
+// services is instantiated. The host module serves all plugins in the runtime,
+// each [ClientConn] dispatches calls from its plugin to the [Server] configured
+// using [WithHostServer].
+func InstantiateHostModule(ctx context.Context, runtime wazero.Runtime) (api.Module, error) {
+	hostModuleMu.Lock()
+	defer hostModuleMu.Unlock()
+
+	if m := runtime.Module(HostModuleName); m != nil {
+		return m, nil
+	}
+
+	builder := runtime.NewHostModuleBuilder(HostModuleName)
+	exportHostFunction(builder, hostCommandFunctionDefinition, api.GoModuleFunc(hostCommandFn))
+	exportHostFunction(builder, hostResponseFunctionDefinition, api.GoModuleFunc(hostResponseFn))
+	exportHostFunction(builder, hostLogFunctionDefinition, api.GoModuleFunc(hostLogFn))
+
+	m, err := builder.Instantiate(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to instantiate host module: %w", err)
+	}
+
+	return m, nil
+}
+
+func exportHostFunction(builder wazero.HostModuleBuilder, def functionDefinition, fn api.GoModuleFunction) {
+	builder.NewFunctionBuilder().
+		WithGoModuleFunction(fn, def.paramTypes, def.resultTypes).
+		Export(def.name)
+}
+
+// hostCommandFn gets called by the Wasm module to call a host service. It
+// receives a pointer to a memory buffer that contains the request envelope,
+// the same way as the v2 command function exported by the module. The request
+// header contains the outgoing metadata and the deadline of the call in the
+// module. The
+// response envelope, containing the header and trailer metadata, is stored in
+// the ClientConn and its size is returned, so the module can allocate a buffer
+// and fetch the response using hostResponseFn. If the module calls the
+// function outside a call from the host, the returned size is 0.
+func hostCommandFn(ctx context.Context, mod api.Module, stack []uint64) {
+	ptr := api.DecodeU32(stack[0])
+	headerSize := api.DecodeU32(stack[1])
+	bufferSize := api.DecodeU32(stack[2])
+
+	c, ok := clientConnFromContext(ctx)
+	if !ok {
+		stack[0] = api.EncodeU32(0)
+		return
+	}
+
+	c.hostResp = c.handleHostCommand(ctx, mod, ptr, headerSize, bufferSize)
+	stack[0] = api.EncodeU32(uint32(len(c.hostResp))) //nolint:gosec // no risk of overflow
+}
+
+// hostResponseFn gets called by the Wasm module to copy the response of the last
+// host command into the buffer at the given pointer. The buffer must be at
+// least as large as the size returned by hostCommandFn. It returns 1 if the
+// response was copied and 0 otherwise, so the module can fail the call instead
+// of decoding a response that was never written.
+func hostResponseFn(ctx context.Context, mod api.Module, stack []uint64) {
+	ptr := api.DecodeU32(stack[0])
+	stack[0] = api.EncodeU32(0)
+
+	c, ok := clientConnFromContext(ctx)
+	if !ok {
+		return
+	}
+
+	resp := c.hostResp
+	c.hostResp = nil
+
+	err := checkMemoryBounds(mod.Memory(), ptr, uint32(len(resp))) //nolint:gosec // no risk of overflow
+	if err != nil || !mod.Memory().Write(ptr, resp) {
+		c.opts.logger.ErrorContext(ctx, "failed to write host response to Wasm module memory",
+			"pointer", ptr, "size", len(resp), "error", err)
+
+		return
+	}
+
+	stack[0] = api.EncodeU32(1)
+}
+
+// handleHostCommand reads the request envelope of a host command from the
+// module's memory and passes it to the host server.
+func (c *ClientConn) handleHostCommand(
+	ctx context.Context,
+	mod api.Module,
+	ptr, headerSize, bufferSize uint32,
+) []byte {
+	srv := c.opts.hostServer
+	if srv == nil {
+		c.opts.logger.DebugContext(ctx, "Wasm module called host command, but no host server is configured")
+		return encodeErrorEnvelope(status.New(codes.Unimplemented, "no host services configured"), nil)
+	}
+
+	err := checkMemoryBounds(mod.Memory(), ptr, bufferSize)
+	if err != nil || headerSize > bufferSize {
+		c.opts.logger.ErrorContext(ctx, "failed to read host command from Wasm module memory",
+			"pointer", ptr, "size", bufferSize, "error", err)
+		return encodeErrorEnvelope(status.New(codes.Internal, "failed to read host command from Wasm module memory"), nil)
+	}
+
+	input, _ := mod.Memory().Read(ptr, bufferSize)
+
+	// Reject large requests before copying them.
+	err = checkRecvMsgSize(int(bufferSize-headerSize), srv.opts.maxRecvMsgSize)
+	if err != nil {
+		return srv.handleErrorEnvelope(status.Convert(err), nil)
+	}
+
+	// The input is a view into the module's memory, copy the request so the
+	// server does not write its response into the module's memory.
+	input = bytes.Clone(input)
+
+	return srv.handleEnvelope(ctx, input[:headerSize], input[headerSize:])
+}
+
+type clientConnCtxKey struct{}
+
+func contextWithClientConn(ctx context.Context, c *ClientConn) context.Context {
+	return context.WithValue(ctx, clientConnCtxKey{}, c)
+}
+
+func clientConnFromContext(ctx context.Context) (*ClientConn, bool) {
+	c, ok := ctx.Value(clientConnCtxKey{}).(*ClientConn)
+	return c, ok
+}
+
+// hostLogFn gets called by the Wasm module to log a record. It receives a
+// pointer to a memory buffer that contains the encoded record. The record is
+// logged using the logger of the ClientConn that called the module, or the
+// default logger if the module logs outside a call from the host, e.g. while
+// it's initialized.
+func hostLogFn(ctx context.Context, mod api.Module, stack []uint64) {
+	ptr := api.DecodeU32(stack[0])
+	size := api.DecodeU32(stack[1])
+
+	logger := slog.Default()
+	if c, ok := clientConnFromContext(ctx); ok {
+		logger = c.opts.logger
+	}
+
+	err := checkMemoryBounds(mod.Memory(), ptr, size)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to read log record from Wasm module memory", "error", err)
+		return
+	}
+
+	b, _ := mod.Memory().Read(ptr, size)
+
+	var r logRecord
+
+	err = r.unmarshal(b)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to decode log record from Wasm module", "error", err)
+		return
+	}
+
+	if !logger.Handler().Enabled(ctx, r.level) {
+		return
+	}
+
+	rec := slog.NewRecord(r.time, r.level, r.message, 0)
+	if r.method != "" {
+		rec.AddAttrs(slog.String("method", r.method))
+	}
+
+	rec.AddAttrs(r.attrs...)
+
+	_ = logger.Handler().Handle(ctx, rec)
+}
@@ -0,0 +1,153 @@
+package hornet
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/matryer/is"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// hostTestService is the test service exposed by the host to the test plugin.
+type hostTestService struct {
+	testsvc.UnimplementedTestServiceServer
+}
+
+// Echo returns the request together with the incoming metadata "x-test" and
+// whether the context has a deadline, and sets the header "x-host". The request
+// "error" returns an error with the code FailedPrecondition.
+func (hostTestService) Echo(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	if in.GetValue() == "error" {
+		return nil, status.Error(codes.FailedPrecondition, "host error")
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	_, hasDeadline := ctx.Deadline()
+
+	err := grpc.SetHeader(ctx, metadata.Pairs("x-host", "header"))
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapperspb.String(fmt.Sprintf("%s md=%v deadline=%t", in.GetValue(), md.Get("x-test"), hasDeadline)), nil
+}
+
+func TestInstantiateHostModule(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should return module instantiated concurrently", func(t *testing.T) {
+		is := is.New(t)
+
+		r := wazero.NewRuntime(ctx)
+		t.Cleanup(func() { _ = r.Close(ctx) })
+
+		var wg sync.WaitGroup
+
+		mods := make([]api.Module, 8)
+		errs := make([]error, len(mods))
+		for i := range mods {
+			wg.Go(func() { mods[i], errs[i] = InstantiateHostModule(ctx, r) })
+		}
+
+		wg.Wait()
+
+		for i := range mods {
+			is.NoErr(errs[i])
+			is.Equal(mods[i], r.Module(HostModuleName))
+		}
+	})
+}
+
+func TestHostServices(t *testing.T) {
+	ctx := context.Background()
+
+	newHostServer := func() *Server {
+		srv := NewServer()
+		testsvc.RegisterTestServiceServer(srv, hostTestService{})
+
+		return srv
+	}
+
+	t.Run("should propagate metadata and deadline to host service", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t, WithHostServer(newHostServer()))
+
+		var header metadata.MD
+
+		resp, err := client.Echo(
+			metadata.AppendToOutgoingContext(ctx, "x-test", "value"),
+			wrapperspb.String("host:hello"),
+			grpc.Header(&header),
+		)
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), "hello md=[value] deadline=true")
+		is.Equal(header.Get("x-host"), []string{"header"})
+	})
+
+	t.Run("should return error of host service", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t, WithHostServer(newHostServer()))
+
+		_, err := client.Echo(ctx, wrapperspb.String("host:error"))
+		is.Equal(status.Code(err), codes.FailedPrecondition)
+		is.Equal(status.Convert(err).Message(), "host error")
+	})
+
+	t.Run("should fail without host server", func(t *testing.T) {
+		is := is.New(t)
+		client := newTestPluginClient(t)
+
+		_, err := client.Echo(ctx, wrapperspb.String("host:hello"))
+		is.Equal(status.Code(err), codes.Unimplemented)
+	})
+}
+
+func TestHostResponseFn(t *testing.T) {
+	ctx := context.Background()
+	is := is.New(t)
+
+	r := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { _ = r.Close(ctx) })
+
+	mod, err := r.Instantiate(ctx, minimalMemoryModule)
+	is.NoErr(err)
+
+	c := &ClientConn{module: mod, opts: defaultClientOptions}
+	c.opts.logger = slog.New(slog.DiscardHandler)
+	ctx = contextWithClientConn(ctx, c)
+
+	t.Run("should copy response into module memory", func(t *testing.T) {
+		is := is.New(t)
+		c.hostResp = []byte("response")
+
+		stack := []uint64{api.EncodeU32(16)}
+		hostResponseFn(ctx, mod, stack)
+
+		is.Equal(api.DecodeU32(stack[0]), uint32(1))
+
+		got, ok := mod.Memory().Read(16, uint32(len("response")))
+		is.True(ok)
+		is.Equal(string(got), "response")
+	})
+
+	t.Run("should report response outside of module memory", func(t *testing.T) {
+		is := is.New(t)
+		c.hostResp = []byte("response")
+
+		stack := []uint64{api.EncodeU32(mod.Memory().Size() - 1)}
+		hostResponseFn(ctx, mod, stack)
+
+		is.Equal(api.DecodeU32(stack[0]), uint32(0))
+		is.Equal(c.hostResp, nil)
+	})
+}
@@ -0,0 +1,143 @@
+package hornet
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// chainUnaryClientInterceptors chains all unary client interceptors into one,
+// the interceptor set using WithUnaryInterceptor is the outermost one.
+func chainUnaryClientInterceptors(opts *clientOptions) {
+	interceptors := opts.chainUnaryInts
+	if opts.unaryInt != nil {
+		interceptors = append([]grpc.UnaryClientInterceptor{opts.unaryInt}, interceptors...)
+	}
+
+	switch len(interceptors) {
+	case 0:
+		opts.unaryInt = nil
+	case 1:
+		opts.unaryInt = interceptors[0]
+	default:
+		opts.unaryInt = func(
+			ctx context.Context,
+			method string,
+			req, reply any,
+			cc *grpc.ClientConn,
+			invoker grpc.UnaryInvoker,
+			callOpts ...grpc.CallOption,
+		) error {
+			return interceptors[0](ctx, method, req, reply, cc, chainUnaryInvoker(interceptors, 0, invoker), callOpts...)
+		}
+	}
+}
+
+// chainUnaryInvoker returns an invoker that calls the interceptor following
+// the current one, or the final invoker at the end of the chain.
+func chainUnaryInvoker(
+	interceptors []grpc.UnaryClientInterceptor,
+	curr int,
+	finalInvoker grpc.UnaryInvoker,
+) grpc.UnaryInvoker {
+	if curr == len(interceptors)-1 {
+		return finalInvoker
+	}
+
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		next := chainUnaryInvoker(interceptors, curr+1, finalInvoker)
+		return interceptors[curr+1](ctx, method, req, reply, cc, next, opts...)
+	}
+}
+
+// chainStreamClientInterceptors chains all stream client interceptors into
+// one, the interceptor set using WithStreamInterceptor is the outermost one.
+func chainStreamClientInterceptors(opts *clientOptions) {
+	interceptors := opts.chainStreamInts
+	if opts.streamInt != nil {
+		interceptors = append([]grpc.StreamClientInterceptor{opts.streamInt}, interceptors...)
+	}
+
+	switch len(interceptors) {
+	case 0:
+		opts.streamInt = nil
+	case 1:
+		opts.streamInt = interceptors[0]
+	default:
+		opts.streamInt = func(
+			ctx context.Context,
+			desc *grpc.StreamDesc,
+			cc *grpc.ClientConn,
+			method string,
+			streamer grpc.Streamer,
+			callOpts ...grpc.CallOption,
+		) (grpc.ClientStream, error) {
+			return interceptors[0](ctx, desc, cc, method, chainStreamer(interceptors, 0, streamer), callOpts...)
+		}
+	}
+}
+
+// chainStreamer returns a streamer that calls the interceptor following the
+// current one, or the final streamer at the end of the chain.
+func chainStreamer(
+	interceptors []grpc.StreamClientInterceptor,
+	curr int,
+	finalStreamer grpc.Streamer,
+) grpc.Streamer {
+	if curr == len(interceptors)-1 {
+		return finalStreamer
+	}
+
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		next := chainStreamer(interceptors, curr+1, finalStreamer)
+		return interceptors[curr+1](ctx, desc, cc, method, next, opts...)
+	}
+}
+
+// chainUnaryServerInterceptors chains all unary server interceptors into one,
+// the interceptor set using WithUnaryServerInterceptor is the outermost one.
+func chainUnaryServerInterceptors(opts *serverOptions) {
+	interceptors := opts.chainUnaryInts
+	if opts.unaryInt != nil {
+		interceptors = append([]grpc.UnaryServerInterceptor{opts.unaryInt}, interceptors...)
+	}
+
+	switch len(interceptors) {
+	case 0:
+		opts.unaryInt = nil
+	case 1:
+		opts.unaryInt = interceptors[0]
+	default:
+		opts.unaryInt = func(
+			ctx context.Context,
+			req any,
+			info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler,
+		) (any, error) {
+			return interceptors[0](ctx, req, info, chainUnaryHandler(interceptors, 0, info, handler))
+		}
+	}
+}
+
+// chainUnaryHandler returns a handler that calls the interceptor following
+// the current one, or the final handler at the end of the chain.
+func chainUnaryHandler(
+	interceptors []grpc.UnaryServerInterceptor,
+	curr int,
+	info *grpc.UnaryServerInfo,
+	finalHandler grpc.UnaryHandler,
+) grpc.UnaryHandler {
+	if curr == len(interceptors)-1 {
+		return finalHandler
+	}
+
+	return func(ctx context.Context, req any) (any, error) {
+		return interceptors[curr+1](ctx, req, info, chainUnaryHandler(interceptors, curr+1, info, finalHandler))
+	}
+}
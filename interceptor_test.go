@@ -0,0 +1,373 @@
+package hornet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/matryer/is"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestChainUnaryClientInterceptors(t *testing.T) {
+	t.Run("should call interceptors in order", func(t *testing.T) {
+		is := is.New(t)
+
+		var calls []string
+		newInterceptor := func(name string) grpc.UnaryClientInterceptor {
+			return func(
+				ctx context.Context,
+				method string,
+				req, reply any,
+				cc *grpc.ClientConn,
+				invoker grpc.UnaryInvoker,
+				opts ...grpc.CallOption,
+			) error {
+				calls = append(calls, name)
+				return invoker(ctx, method, req, reply, cc, opts...)
+			}
+		}
+
+		var opts clientOptions
+		WithChainUnaryInterceptor(newInterceptor("chain1"), newInterceptor("chain2")).applyClient(&opts)
+		WithUnaryInterceptor(newInterceptor("unary")).applyClient(&opts)
+		WithChainUnaryInterceptor(newInterceptor("chain3")).applyClient(&opts)
+		chainUnaryClientInterceptors(&opts)
+
+		invoker := func(context.Context, string, any, any, *grpc.ClientConn, ...grpc.CallOption) error {
+			calls = append(calls, "invoker")
+			return nil
+		}
+
+		err := opts.unaryInt(context.Background(), "/test.Service/Method", nil, nil, nil, invoker)
+		is.NoErr(err)
+		is.Equal(calls, []string{"unary", "chain1", "chain2", "chain3", "invoker"})
+	})
+
+	t.Run("should not set interceptor without options", func(t *testing.T) {
+		is := is.New(t)
+
+		var opts clientOptions
+		chainUnaryClientInterceptors(&opts)
+
+		is.True(opts.unaryInt == nil)
+	})
+}
+
+func TestChainStreamClientInterceptors(t *testing.T) {
+	t.Run("should call interceptors in order", func(t *testing.T) {
+		is := is.New(t)
+
+		var calls []string
+		newInterceptor := func(name string) grpc.StreamClientInterceptor {
+			return func(
+				ctx context.Context,
+				desc *grpc.StreamDesc,
+				cc *grpc.ClientConn,
+				method string,
+				streamer grpc.Streamer,
+				opts ...grpc.CallOption,
+			) (grpc.ClientStream, error) {
+				calls = append(calls, name)
+				return streamer(ctx, desc, cc, method, opts...)
+			}
+		}
+
+		var opts clientOptions
+		WithChainStreamInterceptor(newInterceptor("chain1"), newInterceptor("chain2")).applyClient(&opts)
+		WithStreamInterceptor(newInterceptor("stream")).applyClient(&opts)
+		chainStreamClientInterceptors(&opts)
+
+		streamer := func(
+			context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption,
+		) (grpc.ClientStream, error) {
+			calls = append(calls, "streamer")
+			return nil, nil
+		}
+
+		_, err := opts.streamInt(context.Background(), &grpc.StreamDesc{}, nil, "/test.Service/Method", streamer)
+		is.NoErr(err)
+		is.Equal(calls, []string{"stream", "chain1", "chain2", "streamer"})
+	})
+}
+
+func TestChainUnaryServerInterceptors(t *testing.T) {
+	t.Run("should call interceptors in order", func(t *testing.T) {
+		is := is.New(t)
+
+		var calls []string
+		newInterceptor := func(name string) grpc.UnaryServerInterceptor {
+			return func(
+				ctx context.Context,
+				req any,
+				info *grpc.UnaryServerInfo,
+				handler grpc.UnaryHandler,
+			) (any, error) {
+				calls = append(calls, name+" "+info.FullMethod)
+				return handler(ctx, req)
+			}
+		}
+
+		var opts serverOptions
+		WithChainUnaryServerInterceptor(newInterceptor("chain1"), newInterceptor("chain2")).applyServer(&opts)
+		WithUnaryServerInterceptor(newInterceptor("unary")).applyServer(&opts)
+		chainUnaryServerInterceptors(&opts)
+
+		handler := func(_ context.Context, req any) (any, error) {
+			calls = append(calls, "handler")
+			return req, nil
+		}
+
+		info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+		resp, err := opts.unaryInt(context.Background(), "request", info, handler)
+		is.NoErr(err)
+		is.Equal(resp, "request")
+		is.Equal(calls, []string{
+			"unary /test.Service/Method",
+			"chain1 /test.Service/Method",
+			"chain2 /test.Service/Method",
+			"handler",
+		})
+	})
+}
+
+// countingStream counts the messages received from the wrapped stream.
+type countingStream struct {
+	grpc.ClientStream
+
+	received int
+}
+
+func (s *countingStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.received++
+	}
+
+	return err
+}
+
+func TestClientConn_Interceptors(t *testing.T) {
+	ctx := context.Background()
+
+	newHostServer := func() *Server {
+		srv := NewServer()
+		testsvc.RegisterTestServiceServer(srv, hostTestService{})
+
+		return srv
+	}
+
+	t.Run("should call unary interceptors around Invoke", func(t *testing.T) {
+		is := is.New(t)
+
+		var calls []string
+		newInterceptor := func(name string) grpc.UnaryClientInterceptor {
+			return func(
+				ctx context.Context,
+				method string,
+				req, reply any,
+				cc *grpc.ClientConn,
+				invoker grpc.UnaryInvoker,
+				opts ...grpc.CallOption,
+			) error {
+				calls = append(calls, name+" "+method)
+				err := invoker(ctx, method, req, reply, cc, opts...)
+				calls = append(calls, name+" done")
+
+				return err
+			}
+		}
+
+		// addMetadata adds metadata to the call, the plugin forwards it to
+		// the host service, which returns it.
+		addMetadata := func(
+			ctx context.Context,
+			method string,
+			req, reply any,
+			cc *grpc.ClientConn,
+			invoker grpc.UnaryInvoker,
+			opts ...grpc.CallOption,
+		) error {
+			ctx = metadata.AppendToOutgoingContext(ctx, "x-test", "interceptor")
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		client := newTestPluginClient(t,
+			WithHostServer(newHostServer()),
+			WithUnaryInterceptor(newInterceptor("outer")),
+			WithChainUnaryInterceptor(newInterceptor("inner"), addMetadata),
+		)
+
+		resp, err := client.Echo(ctx, wrapperspb.String("host:hello"))
+		is.NoErr(err)
+		is.True(strings.HasPrefix(resp.GetValue(), "hello md=[interceptor]"))
+		is.Equal(calls, []string{
+			"outer " + testsvc.EchoFullMethodName,
+			"inner " + testsvc.EchoFullMethodName,
+			"inner done",
+			"outer done",
+		})
+	})
+
+	t.Run("should change error in unary interceptor", func(t *testing.T) {
+		is := is.New(t)
+
+		client := newTestPluginClient(t, WithUnaryInterceptor(func(
+			ctx context.Context,
+			method string,
+			req, reply any,
+			cc *grpc.ClientConn,
+			invoker grpc.UnaryInvoker,
+			opts ...grpc.CallOption,
+		) error {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if status.Code(err) == codes.InvalidArgument {
+				return status.Error(codes.FailedPrecondition, "intercepted: "+status.Convert(err).Message())
+			}
+
+			return err
+		}))
+
+		_, err := client.Echo(ctx, wrapperspb.String("error"))
+		st := status.Convert(err)
+		is.Equal(st.Code(), codes.FailedPrecondition)
+		is.Equal(st.Message(), "intercepted: echo error")
+	})
+
+	t.Run("should call stream interceptors around NewStream", func(t *testing.T) {
+		is := is.New(t)
+
+		var (
+			calls   []string
+			counter *countingStream
+		)
+
+		newInterceptor := func(name string) grpc.StreamClientInterceptor {
+			return func(
+				ctx context.Context,
+				desc *grpc.StreamDesc,
+				cc *grpc.ClientConn,
+				method string,
+				streamer grpc.Streamer,
+				opts ...grpc.CallOption,
+			) (grpc.ClientStream, error) {
+				calls = append(calls, name+" "+method)
+				return streamer(ctx, desc, cc, method, opts...)
+			}
+		}
+
+		wrap := func(
+			ctx context.Context,
+			desc *grpc.StreamDesc,
+			cc *grpc.ClientConn,
+			method string,
+			streamer grpc.Streamer,
+			opts ...grpc.CallOption,
+		) (grpc.ClientStream, error) {
+			if method == testsvc.ChatFullMethodName {
+				return nil, status.Error(codes.PermissionDenied, "chat is not allowed")
+			}
+
+			cs, err := streamer(ctx, desc, cc, method, opts...)
+			if err != nil {
+				return nil, err
+			}
+
+			counter = &countingStream{ClientStream: cs}
+
+			return counter, nil
+		}
+
+		client := newTestPluginClient(t,
+			WithStreamInterceptor(newInterceptor("outer")),
+			WithChainStreamInterceptor(newInterceptor("inner"), wrap),
+		)
+
+		stream, err := client.Count(ctx, wrapperspb.Int64(3))
+		is.NoErr(err)
+
+		for {
+			_, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+
+			is.NoErr(err)
+		}
+
+		is.Equal(counter.received, 3)
+		is.Equal(calls, []string{
+			"outer " + testsvc.CountFullMethodName,
+			"inner " + testsvc.CountFullMethodName,
+		})
+
+		_, err = client.Chat(ctx)
+		is.Equal(status.Code(err), codes.PermissionDenied)
+	})
+}
+
+func TestServer_Interceptors(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should call interceptors with method of host service", func(t *testing.T) {
+		is := is.New(t)
+
+		var calls []string
+		newInterceptor := func(name string) grpc.UnaryServerInterceptor {
+			return func(
+				ctx context.Context,
+				req any,
+				info *grpc.UnaryServerInfo,
+				handler grpc.UnaryHandler,
+			) (any, error) {
+				md, _ := metadata.FromIncomingContext(ctx)
+				calls = append(calls, fmt.Sprintf("%s %s %v", name, info.FullMethod, md.Get("x-test")))
+
+				return handler(ctx, req)
+			}
+		}
+
+		// The interceptors run on the host server, called by the plugin.
+		srv := NewServer(
+			WithUnaryServerInterceptor(newInterceptor("outer")),
+			WithChainUnaryServerInterceptor(newInterceptor("inner")),
+		)
+		testsvc.RegisterTestServiceServer(srv, hostTestService{})
+
+		client := newTestPluginClient(t, WithHostServer(srv))
+
+		ctx := metadata.AppendToOutgoingContext(ctx, "x-test", "value")
+		_, err := client.Echo(ctx, wrapperspb.String("host:hello"))
+		is.NoErr(err)
+		is.Equal(calls, []string{
+			"outer " + testsvc.EchoFullMethodName + " [value]",
+			"inner " + testsvc.EchoFullMethodName + " [value]",
+		})
+	})
+
+	t.Run("should return error of interceptor", func(t *testing.T) {
+		is := is.New(t)
+
+		srv := NewServer(WithUnaryServerInterceptor(func(
+			context.Context, any, *grpc.UnaryServerInfo, grpc.UnaryHandler,
+		) (any, error) {
+			return nil, status.Error(codes.PermissionDenied, "denied by interceptor")
+		}))
+		testsvc.RegisterTestServiceServer(srv, hostTestService{})
+
+		client := newTestPluginClient(t, WithHostServer(srv))
+
+		_, err := client.Echo(ctx, wrapperspb.String("host:hello"))
+		st := status.Convert(err)
+		is.Equal(st.Code(), codes.PermissionDenied)
+		is.Equal(st.Message(), "denied by interceptor")
+	})
+}
@@ -0,0 +1,73 @@
+package hornet
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tetratelabs/wazero/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// defaultMaxRecvMsgSize is the default maximum size of a received message,
+	// the same as in gRPC.
+	defaultMaxRecvMsgSize = 4 << 20 // 4 MiB
+	// defaultMaxSendMsgSize is the default maximum size of a sent message, the
+	// same as in gRPC.
+	defaultMaxSendMsgSize = math.MaxInt32
+)
+
+// checkSendMsgSize returns an error with the code ResourceExhausted if the
+// size of a message that is about to be sent is larger than limit.
+func checkSendMsgSize(size, limit int) error {
+	if size > limit {
+		return status.Errorf(codes.ResourceExhausted, "trying to send message larger than max (%d vs. %d)", size, limit)
+	}
+
+	return nil
+}
+
+// checkRecvMsgSize returns an error with the code ResourceExhausted if the
+// size of a received message is larger than limit.
+func checkRecvMsgSize(size, limit int) error {
+	if size > limit {
+		return status.Errorf(codes.ResourceExhausted, "received message larger than max (%d vs. %d)", size, limit)
+	}
+
+	return nil
+}
+
+// MemoryBoundsError is returned when a Wasm module hands the host a pointer
+// and size that don't fit in the module's memory. This indicates a bug in the
+// plugin or a plugin that is trying to make the host read or write memory it
+// should not. The error has the code Internal.
+type MemoryBoundsError struct {
+	// Pointer and Size describe the memory region referenced by the module.
+	Pointer uint32
+	Size    uint32
+	// MemorySize is the size of the module's memory in bytes.
+	MemorySize uint32
+}
+
+func (e *MemoryBoundsError) Error() string {
+	return fmt.Sprintf(
+		"Wasm module referenced memory at pointer %d with size %d, which is out of bounds of its memory of size %d",
+		e.Pointer, e.Size, e.MemorySize,
+	)
+}
+
+func (e *MemoryBoundsError) GRPCStatus() *status.Status {
+	return status.New(codes.Internal, e.Error())
+}
+
+// checkMemoryBounds returns a *MemoryBoundsError if the region at the pointer
+// with the given size does not fit in the memory.
+func checkMemoryBounds(mem api.Memory, ptr, size uint32) error {
+	memSize := mem.Size()
+	if uint64(ptr)+uint64(size) > uint64(memSize) {
+		return &MemoryBoundsError{Pointer: ptr, Size: size, MemorySize: memSize}
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package hornet
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/tetratelabs/wazero"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// minimalMemoryModule is a Wasm module exporting a memory of one page.
+var minimalMemoryModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, // magic and version
+	0x05, 0x03, 0x01, 0x00, 0x01, // memory section: 1 memory, min 1 page
+	0x07, 0x0a, 0x01, 0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00, // export "memory"
+}
+
+func TestCheckMemoryBounds(t *testing.T) {
+	ctx := context.Background()
+	is := is.New(t)
+
+	r := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { _ = r.Close(ctx) })
+
+	mod, err := r.Instantiate(ctx, minimalMemoryModule)
+	is.NoErr(err)
+
+	mem := mod.Memory()
+	pageSize := mem.Size()
+
+	t.Run("should accept region within memory", func(t *testing.T) {
+		is := is.New(t)
+
+		is.NoErr(checkMemoryBounds(mem, 0, pageSize))
+		is.NoErr(checkMemoryBounds(mem, pageSize, 0))
+	})
+
+	t.Run("should reject region out of bounds", func(t *testing.T) {
+		is := is.New(t)
+
+		err := checkMemoryBounds(mem, pageSize-1, 2)
+
+		var boundsErr *MemoryBoundsError
+		is.True(errors.As(err, &boundsErr))
+		is.Equal(*boundsErr, MemoryBoundsError{Pointer: pageSize - 1, Size: 2, MemorySize: pageSize})
+		is.Equal(status.Code(err), codes.Internal)
+	})
+
+	t.Run("should reject region overflowing the address space", func(t *testing.T) {
+		is := is.New(t)
+
+		err := checkMemoryBounds(mem, 0xFFFFFFFF, 0xFFFFFFFF)
+
+		var boundsErr *MemoryBoundsError
+		is.True(errors.As(err, &boundsErr))
+	})
+}
+
+func TestCheckMsgSize(t *testing.T) {
+	t.Run("should accept messages up to the limit", func(t *testing.T) {
+		is := is.New(t)
+
+		is.NoErr(checkSendMsgSize(10, 10))
+		is.NoErr(checkRecvMsgSize(10, 10))
+	})
+
+	t.Run("should reject messages over the limit", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(status.Code(checkSendMsgSize(11, 10)), codes.ResourceExhausted)
+		is.Equal(status.Code(checkRecvMsgSize(11, 10)), codes.ResourceExhausted)
+	})
+}
@@ -0,0 +1,247 @@
+package hornet
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Log records are sent from the Wasm module to the host using the host
+// function hornet-v1-host-log, see LogHandler. The record is encoded manually
+// using protowire and corresponds to the following protobuf messages:
+//
+//	message LogRecord {
+//	  int64 time = 1; // in nanoseconds since the Unix epoch
+//	  int64 level = 2;
+//	  string message = 3;
+//	  string method = 4;
+//	  repeated LogAttr attrs = 5;
+//	}
+//
+//	message LogAttr {
+//	  string key = 1;
+//	  oneof value {
+//	    string string = 2;
+//	    int64 int64 = 3;
+//	    uint64 uint64 = 4;
+//	    double float64 = 5;
+//	    bool bool = 6;
+//	    int64 duration = 7; // in nanoseconds
+//	    int64 time = 8; // in nanoseconds since the Unix epoch
+//	    LogGroup group = 9;
+//	  }
+//	}
+//
+//	message LogGroup {
+//	  repeated LogAttr attrs = 1;
+//	}
+//
+// Values of other kinds are resolved and sent as strings.
+
+// logRecord is a log record sent by the Wasm module to the host.
+type logRecord struct {
+	time    time.Time
+	level   slog.Level
+	message string
+	// method is the full method name of the RPC the module was handling when
+	// the record was logged, empty if it's not known.
+	method string
+	attrs  []slog.Attr
+}
+
+func (r *logRecord) appendTo(b []byte) []byte {
+	if !r.time.IsZero() {
+		b = protowire.AppendTag(b, 1, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(r.time.UnixNano())) //nolint:gosec // decoded as int64
+	}
+
+	if r.level != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(r.level)) //nolint:gosec // decoded as int64
+	}
+
+	if r.message != "" {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendString(b, r.message)
+	}
+
+	if r.method != "" {
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, r.method)
+	}
+
+	return appendLogAttrs(b, 5, r.attrs)
+}
+
+func (r *logRecord) unmarshal(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			r.time = time.Unix(0, int64(v)) //nolint:gosec // encoded from an int64
+
+			return n, nil
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			r.level = slog.Level(int64(v)) //nolint:gosec // encoded from an int64
+
+			return n, nil
+		case num == 3 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			r.message = v
+
+			return n, nil
+		case num == 4 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			r.method = v
+
+			return n, nil
+		case num == 5 && typ == protowire.BytesType:
+			return consumeLogAttr(b, &r.attrs)
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+}
+
+// appendLogAttrs appends each attribute as a LogAttr message with the given
+// field number.
+func appendLogAttrs(b []byte, num protowire.Number, attrs []slog.Attr) []byte {
+	for _, a := range attrs {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendLogAttr(nil, a))
+	}
+
+	return b
+}
+
+// appendLogAttr appends the fields of the LogAttr message describing a.
+func appendLogAttr(b []byte, a slog.Attr) []byte {
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendString(b, a.Key)
+
+	v := a.Value.Resolve()
+
+	switch v.Kind() {
+	case slog.KindString:
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, v.String())
+	case slog.KindInt64:
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v.Int64())) //nolint:gosec // decoded as int64
+	case slog.KindUint64:
+		b = protowire.AppendTag(b, 4, protowire.VarintType)
+		b = protowire.AppendVarint(b, v.Uint64())
+	case slog.KindFloat64:
+		b = protowire.AppendTag(b, 5, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v.Float64()))
+	case slog.KindBool:
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v.Bool()))
+	case slog.KindDuration:
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v.Duration())) //nolint:gosec // decoded as int64
+	case slog.KindTime:
+		b = protowire.AppendTag(b, 8, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v.Time().UnixNano())) //nolint:gosec // decoded as int64
+	case slog.KindGroup:
+		b = protowire.AppendTag(b, 9, protowire.BytesType)
+		b = protowire.AppendBytes(b, appendLogAttrs(nil, 1, v.Group()))
+	default:
+		var s string
+		if err, ok := v.Any().(error); ok {
+			s = err.Error()
+		} else {
+			s = fmt.Sprint(v.Any())
+		}
+
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+
+	return b
+}
+
+// consumeLogAttr decodes a length-prefixed LogAttr message from b and adds it
+// to attrs. It returns the number of bytes consumed.
+func consumeLogAttr(b []byte, attrs *[]slog.Attr) (int, error) {
+	msg, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return n, nil
+	}
+
+	var a slog.Attr
+
+	err := consumeFields(msg, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			a.Key = v
+
+			return n, nil
+		case num == 2 && typ == protowire.BytesType:
+			v, n := protowire.ConsumeString(b)
+			a.Value = slog.StringValue(v)
+
+			return n, nil
+		case num == 5 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			a.Value = slog.Float64Value(math.Float64frombits(v))
+
+			return n, nil
+		case num == 9 && typ == protowire.BytesType:
+			group, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return n, nil
+			}
+
+			var groupAttrs []slog.Attr
+
+			err := consumeFields(group, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+				if num == 1 && typ == protowire.BytesType {
+					return consumeLogAttr(b, &groupAttrs)
+				}
+
+				return protowire.ConsumeFieldValue(num, typ, b), nil
+			})
+			a.Value = slog.GroupValue(groupAttrs...)
+
+			return n, err
+		case num >= 3 && num <= 8 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			a.Value = logValueFromVarint(num, v)
+
+			return n, nil
+		default:
+			return protowire.ConsumeFieldValue(num, typ, b), nil
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	*attrs = append(*attrs, a)
+
+	return n, nil
+}
+
+// logValueFromVarint decodes the value of a LogAttr field encoded as a varint.
+//
+//nolint:gosec // the values were encoded from the corresponding types
+func logValueFromVarint(num protowire.Number, v uint64) slog.Value {
+	switch num {
+	case 3:
+		return slog.Int64Value(int64(v))
+	case 4:
+		return slog.Uint64Value(v)
+	case 6:
+		return slog.BoolValue(protowire.DecodeBool(v))
+	case 7:
+		return slog.DurationValue(time.Duration(v))
+	default:
+		return slog.TimeValue(time.Unix(0, int64(v)))
+	}
+}
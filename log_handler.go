@@ -0,0 +1,127 @@
+//go:build wasm
+
+package hornet
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"slices"
+
+	"google.golang.org/grpc"
+)
+
+// hostLog sends a log record to the host. It receives a pointer to a memory
+// buffer that contains the encoded record.
+//
+//go:wasmimport hornet hornet-v1-host-log
+func hostLog(ptr uintptr, size uint32)
+
+var _ slog.Handler = (*LogHandler)(nil)
+
+// LogHandler is a slog.Handler that sends log records to the host, where they
+// are logged using the logger of the [ClientConn] (see [WithLogger]), keeping
+// their levels and attributes. Records logged with a context of an RPC, e.g.
+// using slog.InfoContext in the implementation of an RPC, contain the full
+// method name in the attribute "method". Plugins loaded by a [Manager] also
+// contain the plugin name in the attribute "plugin".
+//
+// To send all logs of the plugin to the host, set it as the default handler:
+//
+//	slog.SetDefault(slog.New(hornet.NewLogHandler(slog.LevelDebug)))
+type LogHandler struct {
+	level slog.Leveler
+	// goas contains the groups and attributes added using WithGroup and
+	// WithAttrs, in the order they were added.
+	goas []groupOrAttrs
+}
+
+// groupOrAttrs is either a group name or a list of attributes.
+type groupOrAttrs struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewLogHandler creates a LogHandler that sends records with the given minimum
+// level to the host. If level is nil, the handler sends records with level
+// Info or higher. Note that the host logger can filter out the records further.
+func NewLogHandler(level slog.Leveler) *LogHandler {
+	if level == nil {
+		level = slog.LevelInfo
+	}
+
+	return &LogHandler{level: level}
+}
+
+// Enabled reports whether the handler handles records at the given level.
+func (h *LogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle sends the record to the host.
+func (h *LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	// Nest the attributes of the record in the groups, from the innermost
+	// group out.
+	for _, goa := range slices.Backward(h.goas) {
+		if goa.group == "" {
+			attrs = append(slices.Clip(goa.attrs), attrs...)
+			continue
+		}
+
+		if len(attrs) == 0 {
+			// Empty groups are omitted.
+			continue
+		}
+
+		attrs = []slog.Attr{{Key: goa.group, Value: slog.GroupValue(attrs...)}}
+	}
+
+	rec := logRecord{
+		time:    r.Time,
+		level:   r.Level,
+		message: r.Message,
+		attrs:   attrs,
+	}
+	if ctx != nil {
+		rec.method, _ = grpc.Method(ctx)
+	}
+
+	b := buffer(rec.appendTo(nil))
+	hostLog(b.Pointer(), uint32(len(b))) //nolint:gosec // no risk of overflow
+	runtime.KeepAlive(b)
+
+	return nil
+}
+
+// WithAttrs returns a new LogHandler whose records contain the given
+// attributes.
+func (h *LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	return h.with(groupOrAttrs{attrs: attrs})
+}
+
+// WithGroup returns a new LogHandler that nests the attributes added later in
+// the given group.
+func (h *LogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return h.with(groupOrAttrs{group: name})
+}
+
+func (h *LogHandler) with(goa groupOrAttrs) *LogHandler {
+	return &LogHandler{
+		level: h.level,
+		goas:  append(slices.Clip(h.goas), goa),
+	}
+}
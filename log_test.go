@@ -0,0 +1,176 @@
+package hornet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/matryer/is"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestLogRecord_RoundTrip(t *testing.T) {
+	t.Run("should encode and decode all kinds of attributes", func(t *testing.T) {
+		is := is.New(t)
+		now := time.Unix(1700000000, 123)
+		want := logRecord{
+			time:    now,
+			level:   slog.LevelDebug,
+			message: "hello",
+			method:  "/test.Service/Method",
+			attrs: []slog.Attr{
+				slog.String("string", "value"),
+				slog.Int("int", -42),
+				slog.Uint64("uint", 42),
+				slog.Float64("float", 1.5),
+				slog.Bool("bool", true),
+				slog.Duration("duration", time.Second),
+				slog.Time("time", now),
+				slog.Group("group", slog.Int("a", 1), slog.Group("nested", slog.String("b", "c"))),
+			},
+		}
+
+		var got logRecord
+		err := got.unmarshal(want.appendTo(nil))
+
+		is.NoErr(err)
+		is.True(got.time.Equal(want.time))
+		is.Equal(got.level, want.level)
+		is.Equal(got.message, want.message)
+		is.Equal(got.method, want.method)
+		is.Equal(len(got.attrs), len(want.attrs))
+
+		for i := range want.attrs {
+			is.True(got.attrs[i].Equal(want.attrs[i])) // attribute differs
+		}
+	})
+
+	t.Run("should send other values as strings", func(t *testing.T) {
+		is := is.New(t)
+		want := logRecord{
+			attrs: []slog.Attr{
+				slog.Any("error", errors.New("boom")),
+				slog.Any("slice", []int{1, 2}),
+			},
+		}
+
+		var got logRecord
+		err := got.unmarshal(want.appendTo(nil))
+
+		is.NoErr(err)
+		is.Equal(got.attrs, []slog.Attr{
+			slog.String("error", "boom"),
+			slog.String("slice", "[1 2]"),
+		})
+	})
+}
+
+func TestHostLogFn(t *testing.T) {
+	ctx := context.Background()
+	is := is.New(t)
+
+	r := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { _ = r.Close(ctx) })
+
+	mod, err := r.Instantiate(ctx, minimalMemoryModule)
+	is.NoErr(err)
+
+	var out bytes.Buffer
+
+	c := &ClientConn{module: mod, opts: defaultClientOptions}
+	c.opts.logger = slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelInfo})).
+		With("plugin", "test")
+	ctx = contextWithClientConn(ctx, c)
+
+	log := func(r logRecord) {
+		b := r.appendTo(nil)
+		is.True(mod.Memory().Write(0, b))
+		hostLogFn(ctx, mod, []uint64{0, api.EncodeU32(uint32(len(b)))})
+	}
+
+	t.Run("should log record using the logger of the ClientConn", func(t *testing.T) {
+		is := is.New(t)
+		out.Reset()
+
+		log(logRecord{
+			level:   slog.LevelWarn,
+			message: "hello",
+			method:  "/test.Service/Method",
+			attrs:   []slog.Attr{slog.Int("count", 3), slog.Group("g", slog.Bool("ok", true))},
+		})
+
+		var got map[string]any
+		is.NoErr(json.Unmarshal(out.Bytes(), &got))
+		is.Equal(got["level"], "WARN")
+		is.Equal(got["msg"], "hello")
+		is.Equal(got["plugin"], "test")
+		is.Equal(got["method"], "/test.Service/Method")
+		is.Equal(got["count"], float64(3))
+		is.Equal(got["g"], map[string]any{"ok": true})
+	})
+
+	t.Run("should skip records below the level of the logger", func(t *testing.T) {
+		is := is.New(t)
+		out.Reset()
+
+		log(logRecord{level: slog.LevelDebug, message: "hello"})
+
+		is.Equal(out.Len(), 0)
+	})
+}
+
+func TestLogHandler_Plugin(t *testing.T) {
+	ctx := context.Background()
+	is := is.New(t)
+
+	var out bytes.Buffer
+
+	client := newTestPluginClient(t, WithLogger(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+		// Drop the time, so records can be compared as a whole.
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))))
+
+	_, err := client.Echo(ctx, wrapperspb.String("log"))
+	is.NoErr(err)
+
+	var got []map[string]any
+
+	dec := json.NewDecoder(&out)
+	for dec.More() {
+		var rec map[string]any
+		is.NoErr(dec.Decode(&rec))
+		got = append(got, rec)
+	}
+
+	is.Equal(got, []map[string]any{
+		{
+			"level":  "INFO",
+			"msg":    "nested",
+			"method": testsvc.EchoFullMethodName,
+			"a":      float64(1),
+			"g":      map[string]any{"b": true, "h": map[string]any{"c": "x"}},
+		},
+		{
+			"level":  "WARN",
+			"msg":    "empty groups",
+			"method": testsvc.EchoFullMethodName,
+		},
+		{
+			"level": "INFO",
+			"msg":   "no context",
+		},
+	})
+}
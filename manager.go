@@ -0,0 +1,398 @@
+package hornet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// wasmExt is the file extension of Wasm plugins.
+const wasmExt = ".wasm"
+
+type managerOptions struct {
+	runtimeConfig wazero.RuntimeConfig
+	clientOpts    []ClientOption
+	swapOpts      []SwapOption
+}
+
+func defaultManagerOptions() managerOptions {
+	return managerOptions{
+		runtimeConfig: wazero.NewRuntimeConfig().WithCloseOnContextDone(true),
+	}
+}
+
+// Manager loads Wasm plugins into a single runtime and keeps track of them by
+// name. It owns the runtime, including WASI and the host module, and closes
+// everything with a single call to Close.
+type Manager struct {
+	opts    managerOptions
+	logger  *slog.Logger
+	runtime wazero.Runtime
+
+	mu      sync.Mutex // guards following fields
+	plugins []*Plugin
+	// dirs are the file systems passed to LoadFS, watched for new plugins.
+	dirs   []pluginDir
+	closed bool
+}
+
+// Plugin is a Wasm plugin loaded by a [Manager].
+type Plugin struct {
+	name string
+	conn *SwapConn
+	// file is the file the plugin was loaded from, nil if it was loaded from
+	// bytes.
+	file *pluginFile
+}
+
+// pluginFile is a Wasm file a plugin was loaded from.
+type pluginFile struct {
+	fsys fs.FS
+	path string
+	opts []ClientOption
+
+	mu   sync.Mutex // guards stat
+	stat fileStat
+}
+
+// pluginDir is a file system plugins were loaded from.
+type pluginDir struct {
+	fsys fs.FS
+	opts []ClientOption
+}
+
+// fileStat is used to detect changes of a file.
+type fileStat struct {
+	modTime int64
+	size    int64
+}
+
+func statFile(fsys fs.FS, name string) (fileStat, error) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return fileStat{}, err
+	}
+
+	return fileStat{modTime: info.ModTime().UnixNano(), size: info.Size()}, nil
+}
+
+// Name returns the name the plugin is tracked by in the [Manager].
+func (p *Plugin) Name() string { return p.name }
+
+// Module returns the instantiated Wasm module of the plugin. The module
+// changes when the plugin is reloaded, see [Manager.Watch].
+func (p *Plugin) Module() api.Module { return p.conn.Conn().module }
+
+// Conn returns the connection to the plugin. It can be passed to gRPC client
+// constructors generated by protoc-gen-go-grpc. The connection stays valid
+// when the plugin is reloaded, see [Manager.Watch], or replaced using
+// [SwapConn.Swap].
+func (p *Plugin) Conn() *SwapConn { return p.conn }
+
+// NewManager creates a runtime and sets up WASI and the host module in it. By
+// default, the runtime is created with
+// wazero.RuntimeConfig.WithCloseOnContextDone, so that plugin calls can be
+// interrupted, see [WithManagerRuntimeConfig]. Interrupting a call closes the
+// plugin, so a plugin whose handler keeps running past the deadline of a call
+// fails all further calls until it's reloaded or replaced. Handlers that honor
+// their context return before that, as they see the deadline earlier than the
+// host, see [WithDeadlineMargin].
+//
+// The manager must be closed by the caller when no longer needed.
+func NewManager(ctx context.Context, opt ...ManagerOption) (*Manager, error) {
+	opts := defaultManagerOptions()
+	for _, o := range opt {
+		o.applyManager(&opts)
+	}
+
+	r := wazero.NewRuntimeWithConfig(ctx, opts.runtimeConfig)
+
+	_, err := wasi_snapshot_preview1.Instantiate(ctx, r)
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+
+	_, err = InstantiateHostModule(ctx, r)
+	if err != nil {
+		_ = r.Close(ctx)
+		return nil, err
+	}
+
+	clientOpts := defaultClientOptions
+	for _, o := range opts.clientOpts {
+		o.applyClient(&clientOpts)
+	}
+
+	return &Manager{
+		opts:    opts,
+		logger:  clientOpts.logger,
+		runtime: r,
+	}, nil
+}
+
+// Runtime returns the runtime the plugins are loaded into.
+func (m *Manager) Runtime() wazero.Runtime { return m.runtime }
+
+// Load instantiates the Wasm module from the given source and tracks it under
+// the given name. The name must be unique. The options are applied after the
+// client options passed to the manager using [WithManagerClientOptions].
+//
+// The module is compiled on every call, including reloads, unless the runtime
+// is configured with a compilation cache, see [WithManagerRuntimeConfig] and
+// [NewCompilationCache].
+func (m *Manager) Load(ctx context.Context, name string, source []byte, opt ...ClientOption) (*Plugin, error) {
+	return m.load(ctx, name, source, nil, opt)
+}
+
+// LoadFile loads the plugin from the Wasm file at the given path, see
+// [Manager.Load]. The plugin is named after the file without the .wasm
+// extension.
+func (m *Manager) LoadFile(ctx context.Context, path string, opt ...ClientOption) (*Plugin, error) {
+	return m.loadFile(ctx, os.DirFS(filepath.Dir(path)), filepath.Base(path), opt)
+}
+
+// LoadFS loads all files with the .wasm extension in the root directory of
+// fsys, see [Manager.Load]. Use os.DirFS to load the plugins in a directory.
+// Each plugin is named after its file without the .wasm extension.
+//
+// Plugins that fail to load don't prevent the other plugins from being loaded.
+// The returned error contains the errors of all plugins that failed to load.
+func (m *Manager) LoadFS(ctx context.Context, fsys fs.FS, opt ...ClientOption) ([]*Plugin, error) {
+	names, err := fs.Glob(fsys, "*"+wasmExt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list plugins: %w", err)
+	}
+
+	m.mu.Lock()
+	m.dirs = append(m.dirs, pluginDir{fsys: fsys, opts: opt})
+	m.mu.Unlock()
+
+	var (
+		plugins []*Plugin
+		errs    []error
+	)
+
+	for _, name := range names {
+		p, err := m.loadFile(ctx, fsys, name, opt)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		plugins = append(plugins, p)
+	}
+
+	return plugins, errors.Join(errs...)
+}
+
+func (m *Manager) loadFile(ctx context.Context, fsys fs.FS, name string, opt []ClientOption) (*Plugin, error) {
+	pluginName := strings.TrimSuffix(path.Base(name), wasmExt)
+
+	// Stat the file before reading it, so that changes made while reading
+	// are detected by Watch.
+	stat, err := statFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin %q: %w", pluginName, err)
+	}
+
+	source, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin %q: %w", pluginName, err)
+	}
+
+	return m.load(ctx, pluginName, source, &pluginFile{fsys: fsys, path: name, opts: opt, stat: stat}, opt)
+}
+
+func (m *Manager) load(
+	ctx context.Context,
+	name string,
+	source []byte,
+	file *pluginFile,
+	opt []ClientOption,
+) (*Plugin, error) {
+	if name == "" {
+		return nil, errors.New("plugin name must not be empty")
+	}
+
+	// Fail early, the name is checked again once the plugin is instantiated,
+	// the lock is not held while compiling.
+	m.mu.Lock()
+	err := m.checkNameLocked(name)
+	m.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	module, conn, err := m.instantiate(ctx, name, source, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkNameLocked(name); err != nil {
+		_ = module.Close(ctx)
+		return nil, err
+	}
+
+	p := &Plugin{name: name, conn: NewSwapConn(conn, m.opts.swapOpts...), file: file}
+	m.plugins = append(m.plugins, p)
+
+	return p, nil
+}
+
+// Replace replaces the Wasm module of the loaded plugin with the given name by
+// a module instantiated from the given source, e.g. a new version of the
+// plugin. Clients of the plugin keep working, see [SwapConn.Swap] for details.
+// If the new module fails to load, the plugin keeps its previous module.
+func (m *Manager) Replace(ctx context.Context, name string, source []byte, opt ...ClientOption) error {
+	p, ok := m.Plugin(name)
+	if !ok {
+		return status.Errorf(codes.NotFound, "plugin %q is not loaded", name)
+	}
+
+	_, conn, err := m.instantiate(ctx, name, source, opt)
+	if err != nil {
+		return err
+	}
+
+	return p.conn.Swap(ctx, conn)
+}
+
+// checkNameLocked returns an error if a plugin with the given name can't be
+// loaded. The caller must hold m.mu.
+func (m *Manager) checkNameLocked(name string) error {
+	if m.closed {
+		return errors.New("manager is closed")
+	}
+
+	if slices.ContainsFunc(m.plugins, func(p *Plugin) bool { return p.name == name }) {
+		return fmt.Errorf("plugin %q is already loaded", name)
+	}
+
+	return nil
+}
+
+// instantiate compiles and instantiates the Wasm module of a plugin.
+func (m *Manager) instantiate(
+	ctx context.Context,
+	name string,
+	source []byte,
+	opt []ClientOption,
+) (api.Module, *ClientConn, error) {
+	compiled, err := m.runtime.CompileModule(ctx, source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to compile plugin %q: %w", name, err)
+	}
+	// Closing the compiled module is safe, the instantiated module keeps
+	// working until it's closed.
+	defer compiled.Close(ctx)
+
+	// Attach the plugin name to the logger after all other options are
+	// applied, so it's also attached to logs forwarded by the plugin.
+	nameLogger := clientOptionFunc(func(o *clientOptions) { o.logger = o.logger.With("plugin", name) })
+
+	module, conn, err := instantiateClient(
+		ctx, m.runtime, compiled, newModuleConfig(),
+		append(append(slices.Clip(m.opts.clientOpts), opt...), nameLogger),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load plugin %q: %w", name, err)
+	}
+
+	return module, conn, nil
+}
+
+// Plugin returns the plugin with the given name.
+func (m *Manager) Plugin(name string) (*Plugin, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := slices.IndexFunc(m.plugins, func(p *Plugin) bool { return p.name == name })
+	if i == -1 {
+		return nil, false
+	}
+
+	return m.plugins[i], true
+}
+
+// Plugins returns all loaded plugins in the order they were loaded.
+func (m *Manager) Plugins() []*Plugin {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.plugins)
+}
+
+func (m *Manager) isClosed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.closed
+}
+
+// Close closes all plugins in the reverse order they were loaded, and then the
+// runtime. The returned error contains the errors of all plugins that failed
+// to close, annotated with the plugin name.
+func (m *Manager) Close(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+
+	// Close the plugins without holding the lock, so that closing them
+	// doesn't block other methods of the manager.
+	m.closed = true
+	plugins := m.plugins
+	m.plugins = nil
+	m.mu.Unlock()
+
+	var errs []error
+
+	for _, p := range slices.Backward(plugins) {
+		err := p.conn.Close(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to close plugin %q: %w", p.name, err))
+		}
+	}
+
+	err := m.runtime.Close(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to close runtime: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// PluginClient returns a client for the plugin with the given name, created
+// using newClient, which is typically a constructor generated by
+// protoc-gen-go-grpc. It returns an error with the code NotFound if the
+// plugin is not loaded.
+func PluginClient[T any](m *Manager, name string, newClient func(grpc.ClientConnInterface) T) (T, error) {
+	p, ok := m.Plugin(name)
+	if !ok {
+		var zeroT T
+		return zeroT, status.Errorf(codes.NotFound, "plugin %q is not loaded", name)
+	}
+
+	return newClient(p.conn), nil
+}
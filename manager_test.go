@@ -0,0 +1,108 @@
+package hornet
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/matryer/is"
+	"github.com/tetratelabs/wazero"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestManager(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should fail to load module that is not a plugin", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := NewManager(ctx)
+		is.NoErr(err)
+		t.Cleanup(func() { _ = m.Close(ctx) })
+
+		_, err = m.Load(ctx, "minimal", minimalMemoryModule)
+		is.True(err != nil)
+
+		_, ok := m.Plugin("minimal")
+		is.True(!ok)
+	})
+
+	t.Run("should collect errors of all plugins in file system", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := NewManager(ctx)
+		is.NoErr(err)
+		t.Cleanup(func() { _ = m.Close(ctx) })
+
+		plugins, err := m.LoadFS(ctx, fstest.MapFS{
+			"a.wasm":     {Data: minimalMemoryModule},
+			"b.wasm":     {Data: []byte("not wasm")},
+			"readme.txt": {Data: []byte("ignored")},
+		})
+		is.Equal(len(plugins), 0)
+		is.True(err != nil)
+		is.True(strings.Contains(err.Error(), `plugin "a"`))
+		is.True(strings.Contains(err.Error(), `plugin "b"`))
+	})
+
+	t.Run("should return NotFound for unknown plugin", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := NewManager(ctx)
+		is.NoErr(err)
+		t.Cleanup(func() { _ = m.Close(ctx) })
+
+		_, err = PluginClient(m, "unknown", func(cc grpc.ClientConnInterface) grpc.ClientConnInterface { return cc })
+		is.Equal(status.Code(err), codes.NotFound)
+	})
+
+	t.Run("should not load plugins after close", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := NewManager(ctx)
+		is.NoErr(err)
+		is.NoErr(m.Close(ctx))
+		is.NoErr(m.Close(ctx))
+
+		_, err = m.Load(ctx, "minimal", minimalMemoryModule)
+		is.True(err != nil)
+	})
+
+	t.Run("should close module with abandoned stream after drain timeout", func(t *testing.T) {
+		is := is.New(t)
+		source := testPluginModule(t)
+
+		m, err := NewManager(ctx,
+			WithManagerRuntimeConfig(wazero.NewRuntimeConfig().WithCompilationCache(testCompilationCache)),
+			WithManagerSwapOptions(WithSwapDrainTimeout(50*time.Millisecond)),
+		)
+		is.NoErr(err)
+		t.Cleanup(func() { _ = m.Close(ctx) })
+
+		p, err := m.Load(ctx, "test", source)
+		is.NoErr(err)
+
+		oldModule := p.Module()
+		client := testsvc.NewTestServiceClient(p.Conn())
+
+		// The stream is never cancelled nor read until the end.
+		stream, err := client.Count(context.Background(), wrapperspb.Int64(-1))
+		is.NoErr(err)
+
+		_, err = stream.Recv()
+		is.NoErr(err)
+
+		is.NoErr(m.Replace(ctx, "test", source))
+		is.True(oldModule.IsClosed())
+
+		resp, err := client.Echo(ctx, wrapperspb.String("hello"))
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), "hello")
+	})
+}
@@ -0,0 +1,88 @@
+package hornet
+
+import (
+	"sync/atomic"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/experimental"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// wasmPageSize is the size of a Wasm memory page in bytes.
+const wasmPageSize = 64 << 10 // 64 KiB
+
+// ErrMemoryLimitExceeded is returned by calls into a Wasm module that were
+// aborted because the module tried to grow its memory beyond the limit set
+// using [WithMemoryLimitPages]. The error has the code ResourceExhausted. The
+// module is closed in that case, further calls fail with the code
+// Unavailable.
+var ErrMemoryLimitExceeded = status.Error(codes.ResourceExhausted, "Wasm module exceeded its memory limit")
+
+// limitedMemory is a memory allocator for a Wasm module that refuses to grow
+// the memory beyond the limit. It records if the module tried to do so, so
+// that the resulting trap can be reported as ErrMemoryLimitExceeded.
+type limitedMemory struct {
+	// limit is the maximum size of the memory in bytes.
+	limit uint64
+	buf   []byte
+	// exceeded is set when the module tried to grow the memory beyond the
+	// limit.
+	exceeded atomic.Bool
+}
+
+var (
+	_ experimental.MemoryAllocator = (*limitedMemory)(nil)
+	_ experimental.LinearMemory    = (*limitedMemory)(nil)
+)
+
+func newLimitedMemory(limitPages uint32) *limitedMemory {
+	return &limitedMemory{limit: uint64(limitPages) * wasmPageSize}
+}
+
+// Allocate implements experimental.MemoryAllocator. A module has a single
+// memory, so the allocator returns itself.
+func (m *limitedMemory) Allocate(capacity, _ uint64) experimental.LinearMemory {
+	m.buf = make([]byte, 0, min(capacity, m.limit))
+	return m
+}
+
+// Reallocate implements experimental.LinearMemory. It returns nil if size is
+// larger than the limit, which makes memory.grow fail in the module.
+func (m *limitedMemory) Reallocate(size uint64) []byte {
+	if size > m.limit {
+		m.exceeded.Store(true)
+		return nil
+	}
+
+	if size <= uint64(cap(m.buf)) {
+		m.buf = m.buf[:size]
+		return m.buf
+	}
+
+	// Grow the capacity exponentially to amortize copying, but never beyond
+	// the limit.
+	buf := make([]byte, size, min(max(size, 2*uint64(cap(m.buf))), m.limit))
+	copy(buf, m.buf)
+	m.buf = buf
+
+	return m.buf
+}
+
+// Free implements experimental.LinearMemory.
+func (m *limitedMemory) Free() {
+	m.buf = nil
+}
+
+// checkCompiledModule returns an error with the code ResourceExhausted if the
+// compiled module requires more memory than the limit to be instantiated.
+func (m *limitedMemory) checkCompiledModule(compiled wazero.CompiledModule) error {
+	for _, def := range compiled.ExportedMemories() {
+		if minBytes := uint64(def.Min()) * wasmPageSize; minBytes > m.limit {
+			return status.Errorf(codes.ResourceExhausted,
+				"Wasm module requires %d bytes of memory, which exceeds the limit of %d bytes", minBytes, m.limit)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package hornet
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/matryer/is"
+	"github.com/tetratelabs/wazero"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestLimitedMemory(t *testing.T) {
+	t.Run("should grow memory up to the limit", func(t *testing.T) {
+		is := is.New(t)
+		m := newLimitedMemory(2)
+		_ = m.Allocate(0, 10*wasmPageSize)
+
+		buf := m.Reallocate(wasmPageSize)
+		is.Equal(len(buf), wasmPageSize)
+
+		buf[0] = 1
+		buf = m.Reallocate(2 * wasmPageSize)
+		is.Equal(len(buf), 2*wasmPageSize)
+		is.Equal(cap(buf), 2*wasmPageSize)
+		is.Equal(buf[0], byte(1))
+		is.True(!m.exceeded.Load())
+	})
+
+	t.Run("should refuse to grow memory beyond the limit", func(t *testing.T) {
+		is := is.New(t)
+		m := newLimitedMemory(2)
+		_ = m.Allocate(0, 10*wasmPageSize)
+
+		is.Equal(m.Reallocate(3*wasmPageSize), nil)
+		is.True(m.exceeded.Load())
+	})
+}
+
+func TestLimitedMemory_CheckCompiledModule(t *testing.T) {
+	ctx := context.Background()
+
+	r := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { _ = r.Close(ctx) })
+
+	compiled, err := r.CompileModule(ctx, minimalMemoryModule)
+	is.New(t).NoErr(err)
+
+	t.Run("should accept module within the limit", func(t *testing.T) {
+		is := is.New(t)
+		is.NoErr(newLimitedMemory(1).checkCompiledModule(compiled))
+	})
+
+	t.Run("should reject module requiring more memory than the limit", func(t *testing.T) {
+		is := is.New(t)
+
+		err := newLimitedMemory(0).checkCompiledModule(compiled)
+		is.Equal(status.Code(err), codes.ResourceExhausted)
+	})
+}
+
+func TestClientConn_MemoryLimit(t *testing.T) {
+	ctx := context.Background()
+
+	// initialPages returns the number of memory pages of the test plugin
+	// after a call to Echo.
+	initialPages := func(is *is.I) uint32 {
+		conn := instantiateTestPlugin(t, newTestRuntime(t, false))
+
+		_, err := testsvc.NewTestServiceClient(conn).Echo(ctx, wrapperspb.String("hello"))
+		is.NoErr(err)
+
+		return uint32(conn.MemoryUsage() / wasmPageSize) //nolint:gosec // no risk of overflow
+	}
+
+	t.Run("should report memory usage of plugin", func(t *testing.T) {
+		is := is.New(t)
+		testPluginModule(t)
+
+		conn := instantiateTestPlugin(t, newTestRuntime(t, false), WithMaxSendMsgSize(8<<20))
+		client := testsvc.NewTestServiceClient(conn)
+
+		_, err := client.Echo(ctx, wrapperspb.String("hello"))
+		is.NoErr(err)
+
+		before := conn.MemoryUsage()
+		is.Equal(before, uint64(conn.module.Memory().Size()))
+
+		// A large payload makes the plugin grow its memory.
+		_, err = client.Echo(ctx, wrapperspb.String(strings.Repeat("x", 2<<20)))
+		is.NoErr(err)
+
+		is.True(conn.MemoryUsage() > before+2<<20)
+		is.Equal(conn.MemoryUsage(), uint64(conn.module.Memory().Size()))
+	})
+
+	t.Run("should fail call exceeding memory limit", func(t *testing.T) {
+		is := is.New(t)
+		testPluginModule(t)
+
+		// Leave 1 MiB of headroom for regular calls.
+		limit := initialPages(is) + 16
+
+		conn := instantiateTestPlugin(t, newTestRuntime(t, false),
+			WithMemoryLimitPages(limit), WithMaxSendMsgSize(8<<20))
+		client := testsvc.NewTestServiceClient(conn)
+
+		resp, err := client.Echo(ctx, wrapperspb.String("hello"))
+		is.NoErr(err)
+		is.Equal(resp.GetValue(), "hello")
+
+		_, err = client.Echo(ctx, wrapperspb.String(strings.Repeat("x", 4<<20)))
+		is.True(errors.Is(err, ErrMemoryLimitExceeded))
+		is.Equal(status.Code(err), codes.ResourceExhausted)
+
+		is.True(conn.MemoryUsage() <= uint64(limit)*wasmPageSize)
+
+		// The module is closed after exceeding the limit.
+		_, err = client.Echo(ctx, wrapperspb.String("hello"))
+		is.Equal(status.Code(err), codes.Unavailable)
+	})
+}
@@ -1,6 +1,16 @@
 package hornet
 
-import "log/slog"
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/sys"
+	"google.golang.org/grpc"
+)
 
 // ClientOption configures the [ClientConn].
 type ClientOption interface {
@@ -46,3 +56,497 @@ func WithLogger(l *slog.Logger) ClientServerOption {
 		serverOptionFunc: func(opt *serverOptions) { opt.logger = l },
 	}
 }
+
+// WithHostServer returns a ClientOption that exposes the services registered
+// in srv to the Wasm module. The plugin can call them using [HostConn]. The
+// runtime must contain the host module, see [InstantiateHostModule].
+//
+// Host services are called synchronously while the host is calling into the
+// plugin, so they must not call back into the same [ClientConn], as that would
+// deadlock.
+func WithHostServer(srv *Server) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) { opt.hostServer = srv })
+}
+
+// WithUnaryInterceptor returns a ClientOption that specifies the interceptor
+// for unary RPCs performed using the [ClientConn]. Only one interceptor can be
+// set using this option, use [WithChainUnaryInterceptor] to add more. The
+// interceptor receives a nil *grpc.ClientConn.
+func WithUnaryInterceptor(i grpc.UnaryClientInterceptor) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) { opt.unaryInt = i })
+}
+
+// WithChainUnaryInterceptor returns a ClientOption that adds interceptors for
+// unary RPCs performed using the [ClientConn]. The first interceptor is the
+// outermost one, and the interceptor set using [WithUnaryInterceptor] is
+// always called before the chained interceptors. The interceptors receive a
+// nil *grpc.ClientConn.
+func WithChainUnaryInterceptor(interceptors ...grpc.UnaryClientInterceptor) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) {
+		opt.chainUnaryInts = append(opt.chainUnaryInts, interceptors...)
+	})
+}
+
+// WithStreamInterceptor returns a ClientOption that specifies the interceptor
+// for streaming RPCs performed using the [ClientConn]. Only one interceptor can
+// be set using this option, use [WithChainStreamInterceptor] to add more. The
+// interceptor receives a nil *grpc.ClientConn.
+func WithStreamInterceptor(i grpc.StreamClientInterceptor) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) { opt.streamInt = i })
+}
+
+// WithChainStreamInterceptor returns a ClientOption that adds interceptors for
+// streaming RPCs performed using the [ClientConn]. The first interceptor is the
+// outermost one, and the interceptor set using [WithStreamInterceptor] is
+// always called before the chained interceptors. The interceptors receive a
+// nil *grpc.ClientConn.
+func WithChainStreamInterceptor(interceptors ...grpc.StreamClientInterceptor) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) {
+		opt.chainStreamInts = append(opt.chainStreamInts, interceptors...)
+	})
+}
+
+// WithDeadlineMargin returns a ClientOption that sets how much earlier than the
+// host the Wasm module sees the deadline of a call. If the runtime closes
+// modules when the context of a call is done (see
+// wazero.RuntimeConfig.WithCloseOnContextDone), the margin gives handlers that
+// honor their context time to return DeadlineExceeded, before the call is
+// interrupted and the module is closed. The margin is capped at half of the
+// time remaining until the deadline. The default is 50 ms, a margin of 0 sends
+// the deadline of the host as is.
+func WithDeadlineMargin(d time.Duration) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) { opt.deadlineMargin = d })
+}
+
+// WithUnaryServerInterceptor returns a ServerOption that specifies the
+// interceptor for unary RPCs handled by the [Server]. Only one interceptor can
+// be set using this option, use [WithChainUnaryServerInterceptor] to add more.
+func WithUnaryServerInterceptor(i grpc.UnaryServerInterceptor) ServerOption {
+	return serverOptionFunc(func(opt *serverOptions) { opt.unaryInt = i })
+}
+
+// WithChainUnaryServerInterceptor returns a ServerOption that adds
+// interceptors for unary RPCs handled by the [Server]. The first interceptor
+// is the outermost one, and the interceptor set using
+// [WithUnaryServerInterceptor] is always called before the chained
+// interceptors.
+func WithChainUnaryServerInterceptor(interceptors ...grpc.UnaryServerInterceptor) ServerOption {
+	return serverOptionFunc(func(opt *serverOptions) {
+		opt.chainUnaryInts = append(opt.chainUnaryInts, interceptors...)
+	})
+}
+
+// WithHealth returns a ServerOption that registers the standard gRPC health
+// service grpc.health.v1.Health on the [Server], see [Server.SetServingStatus].
+// Plugins need it to be watched by the host, see [WatchHealth].
+func WithHealth() ServerOption {
+	return serverOptionFunc(func(opt *serverOptions) { opt.health = true })
+}
+
+// WithMaxRecvMsgSize returns a ClientServerOption that sets the maximum size
+// in bytes of a message the [ClientConn] or [Server] can receive. Larger
+// messages are rejected before they are copied and fail the call with the code
+// ResourceExhausted. The default is 4 MiB, the same as in gRPC. On the
+// [ClientConn], the limit can be overridden per call using
+// grpc.MaxCallRecvMsgSize.
+func WithMaxRecvMsgSize(n int) ClientServerOption {
+	return clientServerOptionFunc{
+		clientOptionFunc: func(opt *clientOptions) { opt.maxRecvMsgSize = n },
+		serverOptionFunc: func(opt *serverOptions) { opt.maxRecvMsgSize = n },
+	}
+}
+
+// WithMaxSendMsgSize returns a ClientServerOption that sets the maximum size
+// in bytes of a message the [ClientConn] or [Server] can send. Sending a larger
+// message fails with the code ResourceExhausted before any memory is allocated
+// for it. The default is math.MaxInt32, the same as in gRPC. On the
+// [ClientConn], the limit can be overridden per call using
+// grpc.MaxCallSendMsgSize.
+func WithMaxSendMsgSize(n int) ClientServerOption {
+	return clientServerOptionFunc{
+		clientOptionFunc: func(opt *clientOptions) { opt.maxSendMsgSize = n },
+		serverOptionFunc: func(opt *serverOptions) { opt.maxSendMsgSize = n },
+	}
+}
+
+// WithMemoryLimitPages returns a ClientOption that limits the memory of the
+// Wasm module to the given number of pages, a page is 64 KiB. When the module
+// tries to grow its memory beyond the limit, the call fails with
+// [ErrMemoryLimitExceeded] and the module is closed.
+//
+// The limit is applied when the module is instantiated, so this option only
+// has an effect in [InstantiateModuleAndClient], [NewClient] ignores it. To
+// limit the memory of modules you instantiate yourself, use
+// wazero.RuntimeConfig.WithMemoryLimitPages.
+func WithMemoryLimitPages(pages uint32) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) { opt.memoryLimitPages = pages })
+}
+
+// WithStdout returns a ClientOption that writes the stdout of the Wasm module
+// to w instead of the host's stdout. If w is nil, the output is discarded.
+//
+// Like all stdio options, it only has an effect in
+// [InstantiateModuleAndClient], [NewClient] ignores it. To route the output of
+// modules you instantiate yourself, use wazero.ModuleConfig.WithStdout.
+func WithStdout(w io.Writer) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) { opt.stdio.stdout = w })
+}
+
+// WithStderr returns a ClientOption that writes the stderr of the Wasm module
+// to w instead of the host's stderr. If w is nil, the output is discarded. See
+// [WithStdout].
+func WithStderr(w io.Writer) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) { opt.stdio.stderr = w })
+}
+
+// WithStdioPrefix returns a ClientOption that prepends the prefix to every line
+// the Wasm module writes to stdout and stderr, e.g. to tell apart the output of
+// multiple plugins. The output is split into lines, an incomplete line is
+// written once it's completed or the module is closed. See [WithStdout].
+func WithStdioPrefix(prefix string) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) { opt.stdio.prefix = prefix })
+}
+
+// WithStdioLogging returns a ClientOption that logs every line the Wasm module
+// writes to stdout and stderr using the logger of the [ClientConn] (see
+// [WithLogger]), instead of writing it to the writers set using [WithStdout]
+// and [WithStderr]. Lines are logged with the given levels and contain the
+// name of the stream in the attribute "stream". See [WithStdioPrefix] for how
+// the output is split into lines.
+func WithStdioLogging(stdoutLevel, stderrLevel slog.Level) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) {
+		opt.stdio.log = true
+		opt.stdio.stdoutLevel = stdoutLevel
+		opt.stdio.stderrLevel = stderrLevel
+	})
+}
+
+// WithStdioCallMethod returns a ClientOption that tags every line the Wasm
+// module writes to stdout and stderr while handling a call with the full
+// method name of the call. Written lines contain the method in square brackets
+// after the prefix, logged lines (see [WithStdioLogging]) contain it in the
+// attribute "method". An incomplete line is written when the call into the
+// module returns, so it's tagged with the method that wrote it. See
+// [WithStdioPrefix] for how the output is split into lines.
+func WithStdioCallMethod() ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) { opt.stdio.method = true })
+}
+
+// WithModuleConfig returns a ClientOption that changes the configuration of the
+// Wasm module using fn, e.g. to set options not covered by the other options
+// starting with WithModule. The functions are called in the order the options
+// are passed, on top of the default configuration described in
+// [InstantiateModuleAndClient]. The start function and stdout and stderr are
+// set after fn is called, use [WithStdout] and [WithStderr] to route the output,
+// and the file system config is replaced if [WithModuleFS] or
+// [WithModuleDir] is used.
+//
+// Like all module options, it only has an effect in
+// [InstantiateModuleAndClient], [NewClient] ignores it.
+func WithModuleConfig(fn func(wazero.ModuleConfig) wazero.ModuleConfig) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) { opt.moduleConfig = append(opt.moduleConfig, fn) })
+}
+
+// WithModuleName returns a ClientOption that sets the name of the Wasm module
+// instance. Module names must be unique in the runtime, so the option can't be
+// used with multiple instances of the same module in one runtime, e.g. in a
+// [Pool], or when replacing a plugin. See [WithModuleConfig].
+func WithModuleName(name string) ClientOption {
+	return WithModuleConfig(func(c wazero.ModuleConfig) wazero.ModuleConfig { return c.WithName(name) })
+}
+
+// WithModuleEnv returns a ClientOption that sets an environment variable of
+// the Wasm module. See [WithModuleConfig].
+func WithModuleEnv(key, value string) ClientOption {
+	return WithModuleConfig(func(c wazero.ModuleConfig) wazero.ModuleConfig { return c.WithEnv(key, value) })
+}
+
+// WithModuleArgs returns a ClientOption that sets the command line arguments
+// of the Wasm module, the first argument is the program name. See
+// [WithModuleConfig].
+func WithModuleArgs(args ...string) ClientOption {
+	return WithModuleConfig(func(c wazero.ModuleConfig) wazero.ModuleConfig { return c.WithArgs(args...) })
+}
+
+// WithModuleFS returns a ClientOption that mounts fsys in the file system of
+// the Wasm module at guestPath, e.g. "/" or "/data". The file system is
+// read-only. By default, the module has no access to the file system. See
+// [WithModuleConfig].
+func WithModuleFS(fsys fs.FS, guestPath string) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) {
+		opt.fsConfig = append(opt.fsConfig, func(c wazero.FSConfig) wazero.FSConfig {
+			return c.WithFSMount(fsys, guestPath)
+		})
+	})
+}
+
+// WithModuleDir returns a ClientOption that mounts the host directory dir in
+// the file system of the Wasm module at guestPath. If readOnly is true, the
+// module can't modify the directory. See [WithModuleFS].
+func WithModuleDir(dir, guestPath string, readOnly bool) ClientOption {
+	return clientOptionFunc(func(opt *clientOptions) {
+		opt.fsConfig = append(opt.fsConfig, func(c wazero.FSConfig) wazero.FSConfig {
+			if readOnly {
+				return c.WithReadOnlyDirMount(dir, guestPath)
+			}
+
+			return c.WithDirMount(dir, guestPath)
+		})
+	})
+}
+
+// WithModuleWalltime returns a ClientOption that sets the source of the wall
+// clock time of the Wasm module, replacing the system clock used by default.
+// See [WithModuleConfig].
+func WithModuleWalltime(walltime sys.Walltime, resolution sys.ClockResolution) ClientOption {
+	return WithModuleConfig(func(c wazero.ModuleConfig) wazero.ModuleConfig {
+		return c.WithWalltime(walltime, resolution)
+	})
+}
+
+// WithModuleNanotime returns a ClientOption that sets the source of the
+// monotonic time of the Wasm module, replacing the system clock used by
+// default. See [WithModuleConfig].
+func WithModuleNanotime(nanotime sys.Nanotime, resolution sys.ClockResolution) ClientOption {
+	return WithModuleConfig(func(c wazero.ModuleConfig) wazero.ModuleConfig {
+		return c.WithNanotime(nanotime, resolution)
+	})
+}
+
+// WithModuleRandSource returns a ClientOption that sets the source of random
+// bytes of the Wasm module, replacing the default source, which is
+// crypto/rand. See [WithModuleConfig].
+func WithModuleRandSource(source io.Reader) ClientOption {
+	return WithModuleConfig(func(c wazero.ModuleConfig) wazero.ModuleConfig { return c.WithRandSource(source) })
+}
+
+// PoolOption configures the [Pool].
+type PoolOption interface {
+	applyPool(opt *poolOptions)
+}
+
+// poolOptionFunc wraps a function that modifies poolOptions into an
+// implementation of the PoolOption interface.
+type poolOptionFunc func(*poolOptions)
+
+func (f poolOptionFunc) applyPool(opt *poolOptions) { f(opt) }
+
+// WithPoolSize returns a PoolOption that sets the minimum and maximum number of
+// instances in the [Pool]. The minimum number of instances is instantiated when
+// the pool is created, further instances are instantiated on demand. By
+// default, the pool starts with 1 instance and grows up to GOMAXPROCS
+// instances.
+func WithPoolSize(minSize, maxSize int) PoolOption {
+	return poolOptionFunc(func(opt *poolOptions) {
+		opt.minSize = minSize
+		opt.maxSize = maxSize
+	})
+}
+
+// WithPoolWaitTimeout returns a PoolOption that limits how long a call waits
+// for an idle instance when the [Pool] is at its maximum size. Calls that time
+// out fail with the code ResourceExhausted. By default, calls wait until their
+// context is done.
+func WithPoolWaitTimeout(d time.Duration) PoolOption {
+	return poolOptionFunc(func(opt *poolOptions) { opt.waitTimeout = d })
+}
+
+// WithPoolHealthCheck returns a PoolOption that sets a function checking the
+// health of an idle instance before it is used. If the function returns an
+// error, the instance is closed and another one is used instead. Instances
+// whose module was closed, e.g. because a call was interrupted, are always
+// discarded.
+func WithPoolHealthCheck(fn func(ctx context.Context, conn *ClientConn) error) PoolOption {
+	return poolOptionFunc(func(opt *poolOptions) { opt.healthCheck = fn })
+}
+
+// WithPoolClientOptions returns a PoolOption that sets the options used to
+// create the [ClientConn] of each instance in the [Pool].
+func WithPoolClientOptions(opt ...ClientOption) PoolOption {
+	return poolOptionFunc(func(opts *poolOptions) {
+		opts.clientOpts = append(opts.clientOpts, opt...)
+	})
+}
+
+// SwapOption configures the [SwapConn].
+type SwapOption interface {
+	applySwap(opt *swapOptions)
+}
+
+// swapOptionFunc wraps a function that modifies swapOptions into an
+// implementation of the SwapOption interface.
+type swapOptionFunc func(*swapOptions)
+
+func (f swapOptionFunc) applySwap(opt *swapOptions) { f(opt) }
+
+// WithSwapDrainTimeout returns a SwapOption that limits how long
+// [SwapConn.Swap] waits for the calls in flight on the replaced connection to
+// finish before closing its module. If the timeout is zero or negative, Swap
+// waits until the calls finish or its context is done. Defaults to 30 seconds.
+func WithSwapDrainTimeout(d time.Duration) SwapOption {
+	return swapOptionFunc(func(opt *swapOptions) { opt.drainTimeout = d })
+}
+
+// ManagerOption configures the [Manager].
+type ManagerOption interface {
+	applyManager(opt *managerOptions)
+}
+
+// managerOptionFunc wraps a function that modifies managerOptions into an
+// implementation of the ManagerOption interface.
+type managerOptionFunc func(*managerOptions)
+
+func (f managerOptionFunc) applyManager(opt *managerOptions) { f(opt) }
+
+// WithManagerRuntimeConfig returns a ManagerOption that sets the config used
+// to create the runtime of the [Manager], e.g. to add a compilation cache (see
+// [NewCompilationCache]). The default config only enables
+// wazero.RuntimeConfig.WithCloseOnContextDone.
+func WithManagerRuntimeConfig(config wazero.RuntimeConfig) ManagerOption {
+	return managerOptionFunc(func(opt *managerOptions) { opt.runtimeConfig = config })
+}
+
+// WithManagerClientOptions returns a ManagerOption that sets the options used
+// to create the [ClientConn] of every plugin loaded by the [Manager].
+func WithManagerClientOptions(opt ...ClientOption) ManagerOption {
+	return managerOptionFunc(func(opts *managerOptions) {
+		opts.clientOpts = append(opts.clientOpts, opt...)
+	})
+}
+
+// WithManagerSwapOptions returns a ManagerOption that sets the options used to
+// create the [SwapConn] of every plugin loaded by the [Manager].
+func WithManagerSwapOptions(opt ...SwapOption) ManagerOption {
+	return managerOptionFunc(func(opts *managerOptions) {
+		opts.swapOpts = append(opts.swapOpts, opt...)
+	})
+}
+
+// WatchOption configures [Manager.Watch].
+type WatchOption interface {
+	applyWatch(opt *watchOptions)
+}
+
+// watchOptionFunc wraps a function that modifies watchOptions into an
+// implementation of the WatchOption interface.
+type watchOptionFunc func(*watchOptions)
+
+func (f watchOptionFunc) applyWatch(opt *watchOptions) { f(opt) }
+
+// WithWatchInterval returns a WatchOption that sets how often the plugin files
+// are checked for changes. Defaults to 1 second.
+func WithWatchInterval(d time.Duration) WatchOption {
+	return watchOptionFunc(func(opt *watchOptions) { opt.interval = d })
+}
+
+// WithWatchHealthCheck returns a WatchOption that sets a function used to check
+// a new version of a plugin before it replaces the previous version. If the
+// function returns an error, the new version is discarded.
+func WithWatchHealthCheck(fn func(ctx context.Context, conn *ClientConn) error) WatchOption {
+	return watchOptionFunc(func(opt *watchOptions) { opt.healthCheck = fn })
+}
+
+// WithWatchOnReload returns a WatchOption that sets a function called after
+// each attempt to load a changed or new plugin. The error is nil if the plugin
+// was loaded, otherwise it describes why the previous version was kept.
+func WithWatchOnReload(fn func(name string, err error)) WatchOption {
+	return watchOptionFunc(func(opt *watchOptions) { opt.onReload = fn })
+}
+
+// HealthOption configures [WatchHealth].
+type HealthOption interface {
+	applyHealth(opt *healthOptions)
+}
+
+// healthOptionFunc wraps a function that modifies healthOptions into an
+// implementation of the HealthOption interface.
+type healthOptionFunc func(*healthOptions)
+
+func (f healthOptionFunc) applyHealth(opt *healthOptions) { f(opt) }
+
+// WithHealthInterval returns a HealthOption that sets how often the health of
+// the plugins is checked. Defaults to 10 seconds.
+func WithHealthInterval(d time.Duration) HealthOption {
+	return healthOptionFunc(func(opt *healthOptions) { opt.interval = d })
+}
+
+// WithHealthTimeout returns a HealthOption that sets the timeout of a single
+// health check. Defaults to 1 second.
+func WithHealthTimeout(d time.Duration) HealthOption {
+	return healthOptionFunc(func(opt *healthOptions) { opt.timeout = d })
+}
+
+// WithHealthService returns a HealthOption that sets the name of the service
+// whose health is checked. Defaults to the empty name, which denotes the
+// health of the plugin as a whole.
+func WithHealthService(service string) HealthOption {
+	return healthOptionFunc(func(opt *healthOptions) { opt.service = service })
+}
+
+// SupervisorOption configures the [Supervisor].
+type SupervisorOption interface {
+	applySupervisor(opt *supervisorOptions)
+}
+
+// supervisorOptionFunc wraps a function that modifies supervisorOptions into
+// an implementation of the SupervisorOption interface.
+type supervisorOptionFunc func(*supervisorOptions)
+
+func (f supervisorOptionFunc) applySupervisor(opt *supervisorOptions) { f(opt) }
+
+// WithSupervisorBackoff returns a SupervisorOption that sets the backoff
+// between consecutive restarts of the Wasm module. The first restart happens
+// right away, the delay before each following restart is doubled, starting
+// with minDelay and capped at maxDelay. The backoff is reset once a call
+// succeeds. Defaults to 100ms and 10s.
+func WithSupervisorBackoff(minDelay, maxDelay time.Duration) SupervisorOption {
+	return supervisorOptionFunc(func(opt *supervisorOptions) {
+		opt.minBackoff = minDelay
+		opt.maxBackoff = maxDelay
+	})
+}
+
+// WithSupervisorRestartBudget returns a SupervisorOption that limits the
+// number of restarts of the Wasm module within the given time window. Once
+// the budget is exceeded, the supervisor stops restarting the module and
+// calls fail with [ErrRestartBudgetExceeded]. Defaults to 5 restarts per
+// minute.
+func WithSupervisorRestartBudget(maxRestarts int, window time.Duration) SupervisorOption {
+	return supervisorOptionFunc(func(opt *supervisorOptions) {
+		opt.maxRestarts = maxRestarts
+		opt.restartWindow = window
+	})
+}
+
+// WithSupervisorRetryPolicy returns a SupervisorOption that sets a function
+// deciding if a unary call, during which the Wasm module died, is retried on a
+// new instance. It's called with the full method name and the error returned
+// by the call. By default, calls are not retried. Only retry calls that are
+// safe to be executed more than once.
+func WithSupervisorRetryPolicy(fn func(method string, err error) bool) SupervisorOption {
+	return supervisorOptionFunc(func(opt *supervisorOptions) { opt.retryPolicy = fn })
+}
+
+// WithSupervisorOnRestart returns a SupervisorOption that sets a function
+// called after each attempt to restart the Wasm module.
+func WithSupervisorOnRestart(fn func(RestartEvent)) SupervisorOption {
+	return supervisorOptionFunc(func(opt *supervisorOptions) { opt.onRestart = fn })
+}
+
+// WithSupervisorClientOptions returns a SupervisorOption that sets the options
+// used to create the [ClientConn] of each instance of the Wasm module.
+func WithSupervisorClientOptions(opt ...ClientOption) SupervisorOption {
+	return supervisorOptionFunc(func(opts *supervisorOptions) {
+		opts.clientOpts = append(opts.clientOpts, opt...)
+	})
+}
+
+// WithSupervisorModuleConfig returns a SupervisorOption that sets the
+// configuration used to instantiate the Wasm module, initially and on every
+// restart. Module config options passed using [WithSupervisorClientOptions]
+// are applied on top of it, and the start functions are always set to
+// initialize the reactor. Defaults to the configuration used by
+// [InstantiateModuleAndClient].
+func WithSupervisorModuleConfig(config wazero.ModuleConfig) SupervisorOption {
+	return supervisorOptionFunc(func(opt *supervisorOptions) { opt.moduleConfig = config })
+}
@@ -0,0 +1,136 @@
+package hornet
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"google.golang.org/grpc"
+)
+
+// testPlugin contains the test plugin built from testdata/plugin. It's built
+// once and shared by all tests.
+var testPlugin struct {
+	once sync.Once
+	wasm []byte
+	err  error
+}
+
+// testCompilationCache is shared by all runtimes created in tests, so that the
+// test plugin is only compiled once. It's stored on disk, as modules compiled
+// in memory are evicted from the cache once they are closed.
+var testCompilationCache wazero.CompilationCache
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "hornet-test")
+	if err != nil {
+		panic(err)
+	}
+
+	testCompilationCache, err = wazero.NewCompilationCacheWithDir(dir)
+	if err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+
+	_ = testCompilationCache.Close(context.Background())
+	_ = os.RemoveAll(dir)
+
+	os.Exit(code)
+}
+
+// testPluginModule returns the test plugin. The test is skipped in short mode
+// or if the go command is not available.
+func testPluginModule(t *testing.T) []byte {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("skipping test using the test plugin in short mode")
+	}
+
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("skipping test using the test plugin, the go command is not available")
+	}
+
+	testPlugin.once.Do(func() {
+		testPlugin.wasm, testPlugin.err = buildTestPlugin(goCmd)
+	})
+
+	if testPlugin.err != nil {
+		t.Fatalf("failed to build test plugin: %v", testPlugin.err)
+	}
+
+	return testPlugin.wasm
+}
+
+func buildTestPlugin(goCmd string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "hornet-test-plugin")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "plugin.wasm")
+
+	cmd := exec.Command(goCmd, "build", "-buildmode=c-shared", "-o", out, "./testdata/plugin")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(out)
+}
+
+// newTestRuntime returns a runtime with WASI. If closeOnContextDone is true,
+// modules are closed when the context of a call is done.
+func newTestRuntime(t *testing.T, closeOnContextDone bool) wazero.Runtime {
+	t.Helper()
+
+	ctx := context.Background()
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(closeOnContextDone).
+		WithCompilationCache(testCompilationCache))
+	t.Cleanup(func() { _ = r.Close(ctx) })
+
+	wasi_snapshot_preview1.MustInstantiate(ctx, r)
+
+	return r
+}
+
+// instantiateTestPlugin instantiates the test plugin in the runtime and
+// returns its connection.
+func instantiateTestPlugin(t *testing.T, r wazero.Runtime, opt ...ClientOption) *ClientConn {
+	t.Helper()
+
+	_, conn, err := InstantiateModuleAndClient(context.Background(), r, testPluginModule(t),
+		func(cc grpc.ClientConnInterface) *ClientConn { return cc.(*ClientConn) },
+		opt...)
+	if err != nil {
+		t.Fatalf("failed to instantiate test plugin: %v", err)
+	}
+
+	return conn
+}
+
+// newTestPluginClient instantiates the test plugin in a new runtime and
+// returns a client of the test service.
+func newTestPluginClient(t *testing.T, opt ...ClientOption) *testsvc.TestServiceClient {
+	t.Helper()
+
+	// Build the plugin before creating the runtime, so the test is skipped
+	// right away if the plugin can't be built.
+	testPluginModule(t)
+
+	return testsvc.NewTestServiceClient(instantiateTestPlugin(t, newTestRuntime(t, false), opt...))
+}
@@ -0,0 +1,318 @@
+package hornet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type poolOptions struct {
+	minSize     int
+	maxSize     int
+	waitTimeout time.Duration
+	healthCheck func(context.Context, *ClientConn) error
+	clientOpts  []ClientOption
+}
+
+func defaultPoolOptions() poolOptions {
+	return poolOptions{
+		minSize: 1,
+		maxSize: runtime.GOMAXPROCS(0),
+	}
+}
+
+var _ grpc.ClientConnInterface = (*Pool)(nil)
+
+// errPoolClosed is returned by calls on a closed Pool.
+var errPoolClosed = status.Error(codes.Unavailable, "pool is closed")
+
+// Pool is a pool of instances of the same Wasm module, to perform RPCs in
+// parallel. It can be passed to gRPC client constructors generated by
+// protoc-gen-go-grpc, the same way as a [ClientConn].
+//
+// Each call is dispatched to an idle instance. If there is no idle instance, a
+// new one is instantiated, until the maximum size of the pool is reached. After
+// that, calls wait for an instance to become idle.
+//
+// Instances don't share memory, so plugins must not rely on state kept between
+// calls, as subsequent calls may be handled by different instances.
+type Pool struct {
+	opts     poolOptions
+	logger   *slog.Logger
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	config   wazero.ModuleConfig
+	// slots limits the number of instances in use. It is a channel with a
+	// buffer of the maximum pool size, so that waiting for it can be
+	// interrupted.
+	slots chan struct{}
+
+	mu     sync.Mutex // guards following fields
+	idle   []*poolInstance
+	lastID int
+	closed bool
+}
+
+// poolInstance is an instance of the Wasm module in a Pool.
+type poolInstance struct {
+	module api.Module
+	conn   *ClientConn
+}
+
+// NewPool compiles the Wasm module from the given source once and creates a
+// pool of its instances. The minimum number of instances is instantiated right
+// away. The instances are configured the same way as in
+// [InstantiateModuleAndClient], client options can be passed using
+// [WithPoolClientOptions].
+//
+// The pool owns the compiled module and closes it when it's closed, so there is
+// no variant taking a wazero.CompiledModule. Each pool compiles the module
+// again, unless the runtime is configured with a compilation cache, see
+// [NewCompilationCache].
+//
+// The pool must be closed by the caller when no longer needed.
+func NewPool(ctx context.Context, runtime wazero.Runtime, source []byte, opt ...PoolOption) (*Pool, error) {
+	opts := defaultPoolOptions()
+	for _, o := range opt {
+		o.applyPool(&opts)
+	}
+
+	if opts.maxSize < 1 || opts.minSize < 0 || opts.minSize > opts.maxSize {
+		return nil, fmt.Errorf("invalid pool size: min %d, max %d", opts.minSize, opts.maxSize)
+	}
+
+	clientOpts := defaultClientOptions
+	for _, o := range opts.clientOpts {
+		o.applyClient(&clientOpts)
+	}
+
+	compiled, err := runtime.CompileModule(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile Wasm module: %w", err)
+	}
+
+	p := &Pool{
+		opts:     opts,
+		logger:   clientOpts.logger,
+		runtime:  runtime,
+		compiled: compiled,
+		config:   newModuleConfig(),
+		slots:    make(chan struct{}, opts.maxSize),
+	}
+
+	for range opts.minSize {
+		inst, err := p.newInstance(ctx)
+		if err != nil {
+			_ = p.Close(ctx)
+			return nil, err
+		}
+
+		p.idle = append(p.idle, inst)
+	}
+
+	return p, nil
+}
+
+// Invoke performs a unary RPC on an idle instance of the Wasm module. See
+// [ClientConn.Invoke] for details.
+func (p *Pool) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	inst, err := p.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.release(inst)
+
+	return inst.conn.Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream begins a streaming RPC on an idle instance of the Wasm module. See
+// [ClientConn.NewStream] for details. The instance is in use until RecvMsg
+// returns an error or the context of the stream is done.
+func (p *Pool) NewStream(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	method string,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	inst, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := inst.conn.NewStream(ctx, desc, method, opts...)
+	if err != nil {
+		p.release(inst)
+		return nil, err
+	}
+
+	return newReleaseStream(ctx, cs, func() { p.release(inst) }), nil
+}
+
+// Close closes the idle instances and the compiled module. Instances that are
+// in use are closed once their call returns. Calls after Close fail with the
+// code Unavailable.
+func (p *Pool) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	errs := make([]error, 0, len(idle)+1)
+	for _, inst := range idle {
+		errs = append(errs, p.discard(ctx, inst))
+	}
+
+	errs = append(errs, p.compiled.Close(ctx))
+
+	return errors.Join(errs...)
+}
+
+// acquire returns an idle instance, instantiating a new one if there is none.
+// If the pool is at its maximum size, it waits for an instance to be released.
+func (p *Pool) acquire(ctx context.Context) (*poolInstance, error) {
+	if p.isClosed() {
+		return nil, errPoolClosed
+	}
+
+	waitCtx := ctx
+	if p.opts.waitTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, p.opts.waitTimeout)
+		defer cancel()
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	case <-waitCtx.Done():
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+
+		return nil, status.Errorf(codes.ResourceExhausted,
+			"no idle instance available in the pool after waiting for %v", p.opts.waitTimeout)
+	}
+
+	for {
+		inst, ok := p.popIdle()
+		if !ok {
+			break
+		}
+
+		if err := p.checkHealth(ctx, inst); err != nil {
+			p.logger.WarnContext(ctx, "discarding unhealthy Wasm module instance", "error", err)
+			_ = p.discard(ctx, inst)
+
+			continue
+		}
+
+		return inst, nil
+	}
+
+	inst, err := p.newInstance(ctx)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+
+	return inst, nil
+}
+
+// release returns the instance to the pool. Instances whose module was closed,
+// e.g. because a call was interrupted, are discarded.
+func (p *Pool) release(inst *poolInstance) {
+	defer func() { <-p.slots }()
+
+	p.mu.Lock()
+	if !p.closed && !inst.module.IsClosed() {
+		p.idle = append(p.idle, inst)
+		p.mu.Unlock()
+
+		return
+	}
+	p.mu.Unlock()
+
+	_ = p.discard(context.Background(), inst)
+}
+
+func (p *Pool) popIdle() (*poolInstance, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle) == 0 {
+		return nil, false
+	}
+
+	// Take the most recently used instance, it's the most likely to be warm.
+	inst := p.idle[len(p.idle)-1]
+	p.idle[len(p.idle)-1] = nil
+	p.idle = p.idle[:len(p.idle)-1]
+
+	return inst, true
+}
+
+// checkHealth returns an error if the instance can't be used anymore.
+func (p *Pool) checkHealth(ctx context.Context, inst *poolInstance) error {
+	if inst.module.IsClosed() {
+		return errModuleClosed
+	}
+
+	if p.opts.healthCheck != nil {
+		return p.opts.healthCheck(ctx, inst.conn)
+	}
+
+	return nil
+}
+
+// newInstance instantiates the Wasm module and creates a client for it.
+func (p *Pool) newInstance(ctx context.Context) (*poolInstance, error) {
+	p.mu.Lock()
+	p.lastID++
+	id := p.lastID
+	p.mu.Unlock()
+
+	config := p.config
+	if name := p.compiled.Name(); name != "" {
+		// Named modules must have a unique name in the runtime.
+		config = config.WithName(fmt.Sprintf("%s-%d", name, id))
+	}
+
+	module, conn, err := instantiateClient(ctx, p.runtime, p.compiled, config, p.opts.clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &poolInstance{module: module, conn: conn}, nil
+}
+
+// discard closes the instance, it must not be in the pool anymore.
+func (p *Pool) discard(ctx context.Context, inst *poolInstance) error {
+	err := inst.module.Close(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to close Wasm module: %w", err)
+	}
+
+	return nil
+}
+
+func (p *Pool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.closed
+}
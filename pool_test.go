@@ -0,0 +1,213 @@
+package hornet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/matryer/is"
+	"github.com/tetratelabs/wazero"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestNewPool(t *testing.T) {
+	ctx := context.Background()
+
+	r := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { _ = r.Close(ctx) })
+
+	t.Run("should reject invalid pool size", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, size := range [][2]int{{0, 0}, {-1, 1}, {2, 1}} {
+			_, err := NewPool(ctx, r, minimalMemoryModule, WithPoolSize(size[0], size[1]))
+			is.True(err != nil)
+		}
+	})
+
+	t.Run("should fail to instantiate module that is not a plugin", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := NewPool(ctx, r, minimalMemoryModule)
+		is.True(err != nil)
+	})
+
+	t.Run("should create empty pool lazily", func(t *testing.T) {
+		is := is.New(t)
+
+		p, err := NewPool(ctx, r, minimalMemoryModule, WithPoolSize(0, 1))
+		is.NoErr(err)
+		is.NoErr(p.Close(ctx))
+
+		err = p.Invoke(ctx, "/test.Service/Method", nil, nil)
+		is.Equal(err, errPoolClosed)
+	})
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+
+	newPool := func(t *testing.T, opt ...PoolOption) *testsvc.TestServiceClient {
+		t.Helper()
+
+		p, err := NewPool(ctx, newTestRuntime(t, false), testPluginModule(t), opt...)
+		if err != nil {
+			t.Fatalf("failed to create pool: %v", err)
+		}
+		t.Cleanup(func() { _ = p.Close(ctx) })
+
+		return testsvc.NewTestServiceClient(p)
+	}
+
+	// echoCalls returns the number of calls to Echo handled by the instance
+	// that handles this call, including this call.
+	echoCalls := func(is *is.I, client *testsvc.TestServiceClient) string {
+		resp, err := client.Echo(ctx, wrapperspb.String("calls"))
+		is.NoErr(err)
+
+		return resp.GetValue()
+	}
+
+	// holdInstance starts a stream that keeps an instance in use until the
+	// context is cancelled and the returned function is called.
+	holdInstance := func(ctx context.Context, is *is.I, client *testsvc.TestServiceClient) func() {
+		stream, err := client.Count(ctx, wrapperspb.Int64(-1))
+		is.NoErr(err)
+
+		_, err = stream.Recv()
+		is.NoErr(err)
+
+		return func() {
+			_, err := stream.Recv()
+			is.Equal(status.Code(err), codes.Canceled)
+		}
+	}
+
+	t.Run("should dispatch call to another instance if instance is in use", func(t *testing.T) {
+		is := is.New(t)
+		client := newPool(t, WithPoolSize(1, 2))
+
+		is.Equal(echoCalls(is, client), "1")
+
+		ctx, cancel := context.WithCancel(ctx)
+		release := holdInstance(ctx, is, client)
+		is.Equal(echoCalls(is, client), "1") // new instance
+
+		cancel()
+		release()
+		is.Equal(echoCalls(is, client), "2") // most recently used instance
+	})
+
+	t.Run("should fail after wait timeout if pool is at maximum size", func(t *testing.T) {
+		is := is.New(t)
+		client := newPool(t, WithPoolSize(1, 1), WithPoolWaitTimeout(50*time.Millisecond))
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		release := holdInstance(ctx, is, client)
+
+		_, err := client.Echo(context.Background(), wrapperspb.String("hello"))
+		is.Equal(status.Code(err), codes.ResourceExhausted)
+
+		cancel()
+		release()
+		is.Equal(echoCalls(is, client), "1")
+	})
+
+	t.Run("should wait for instance if pool is at maximum size", func(t *testing.T) {
+		is := is.New(t)
+		client := newPool(t, WithPoolSize(1, 1))
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		release := holdInstance(ctx, is, client)
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		is.Equal(echoCalls(is, client), "1") // waits until the stream is cancelled
+		release()
+	})
+
+	t.Run("should handle concurrent calls", func(t *testing.T) {
+		is := is.New(t)
+		client := newPool(t, WithPoolSize(0, 4))
+
+		var wg sync.WaitGroup
+
+		errs := make(chan error, 8)
+		for i := range 8 {
+			wg.Go(func() {
+				for j := range 10 {
+					want := fmt.Sprintf("%d-%d", i, j)
+
+					resp, err := client.Echo(ctx, wrapperspb.String(want))
+					if err != nil {
+						errs <- err
+						return
+					}
+
+					if resp.GetValue() != want {
+						errs <- fmt.Errorf("got %q, want %q", resp.GetValue(), want)
+						return
+					}
+				}
+			})
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			is.NoErr(err)
+		}
+	})
+
+	t.Run("should replace closed instance", func(t *testing.T) {
+		is := is.New(t)
+		client := newPool(t, WithPoolSize(1, 1))
+
+		is.Equal(echoCalls(is, client), "1")
+
+		_, err := client.Echo(ctx, wrapperspb.String("exit"))
+		is.True(err != nil)
+
+		is.Equal(echoCalls(is, client), "1") // new instance
+	})
+
+	t.Run("should replace unhealthy instance", func(t *testing.T) {
+		is := is.New(t)
+
+		var checks int
+
+		client := newPool(t, WithPoolSize(1, 1), WithPoolHealthCheck(func(ctx context.Context, conn *ClientConn) error {
+			checks++
+
+			resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+			if err != nil {
+				return err
+			}
+
+			if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+				return errors.New("not serving")
+			}
+
+			return nil
+		}))
+
+		is.Equal(echoCalls(is, client), "1")
+
+		_, err := client.Echo(ctx, wrapperspb.String("unhealthy"))
+		is.NoErr(err)
+
+		is.Equal(echoCalls(is, client), "1") // new instance
+		is.Equal(checks, 3)
+	})
+}
@@ -0,0 +1,79 @@
+//go:build !wasm
+
+package hornet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+)
+
+// ListServices returns the sorted names of the services implemented by the
+// plugin behind the connection, using the gRPC reflection service
+// grpc.reflection.v1.ServerReflection. The plugin needs to register the
+// reflection service, see [Server.GetServiceInfo]. If it doesn't, an error
+// with the code Unimplemented is returned.
+//
+// The reflection service is a bidirectional streaming RPC, so the plugin needs
+// to be built with streaming support (the hornet-v2-stream-* exports). There
+// is no fallback to hornet-v1-command, plugins without streaming support
+// return an error with the code Unimplemented.
+//
+// ListServices is only available on the host, it's excluded from Wasm builds
+// so that plugins don't link the reflection client.
+func ListServices(ctx context.Context, conn grpc.ClientConnInterface) ([]string, error) {
+	// Cancel the stream if it's not finished because of an error.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send reflection request: %w", err)
+	}
+
+	err = stream.CloseSend()
+	if err != nil {
+		return nil, fmt.Errorf("failed to send reflection request: %w", err)
+	}
+
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	if errResp := resp.GetErrorResponse(); errResp != nil {
+		code := codes.Code(errResp.GetErrorCode()) //nolint:gosec // gRPC codes are not negative
+		return nil, status.Error(code, errResp.GetErrorMessage())
+	}
+
+	// Finish the stream, so the plugin is not left with an open stream.
+	_, err = stream.Recv()
+	if !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to finish reflection stream: %w", err)
+	}
+
+	services := resp.GetListServicesResponse().GetService()
+	names := make([]string, 0, len(services))
+
+	for _, svc := range services {
+		names = append(names, svc.GetName())
+	}
+
+	slices.Sort(names)
+
+	return names, nil
+}
@@ -0,0 +1,32 @@
+package hornet
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestListServices(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should list services of plugin", func(t *testing.T) {
+		is := is.New(t)
+		testPluginModule(t)
+
+		conn := instantiateTestPlugin(t, newTestRuntime(t, false))
+
+		services, err := ListServices(ctx, conn)
+		is.NoErr(err)
+		is.Equal(services, []string{
+			"grpc.health.v1.Health",
+			"grpc.reflection.v1.ServerReflection",
+			"hornet.testdata.TestService",
+		})
+
+		// The stream is finished, the plugin keeps working.
+		services, err = ListServices(ctx, conn)
+		is.NoErr(err)
+		is.Equal(len(services), 3)
+	})
+}
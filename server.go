@@ -11,6 +11,9 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
 )
@@ -21,29 +24,49 @@ type serviceInfo struct {
 	// Contains the implementation for the methods in this service.
 	serviceImpl any
 	methods     map[string]*grpc.MethodDesc
+	streams     map[string]*grpc.StreamDesc
+	mdata       any
 }
 
 type serverOptions struct {
 	logger *slog.Logger
+	// unaryInt is the interceptor passed to unary method handlers. After the
+	// options are applied, it contains the chain of all unary interceptors.
+	unaryInt       grpc.UnaryServerInterceptor
+	chainUnaryInts []grpc.UnaryServerInterceptor
+	maxSendMsgSize int
+	maxRecvMsgSize int
+	health         bool
 }
 
 var defaultServerOptions = serverOptions{
-	logger: slog.Default(),
+	logger:         slog.Default(),
+	maxSendMsgSize: defaultMaxSendMsgSize,
+	maxRecvMsgSize: defaultMaxRecvMsgSize,
 }
 
 var _ grpc.ServiceRegistrar = (*Server)(nil)
 
 // Server is a gRPC server that implements the [PluginHandler] interface to
-// be used in Wasm plugins. It supports unary RPCs only.
+// be used in Wasm plugins. It supports unary and streaming RPCs.
 //
 // It is similar to grpc.Server, but it does not implement the net.Listener
 // interface. Instead, it implements the [PluginHandler] interface to
 // process the bytes sent to the plugin as a gRPC request.
+//
+// A Server can also be used on the host to expose host services to plugins,
+// see [WithHostServer]. Host services support unary RPCs only.
+//
+// The standard gRPC health service grpc.health.v1.Health is registered on
+// servers created with [WithHealth], see [Server.SetServingStatus].
 type Server struct {
-	opts serverOptions
+	opts   serverOptions
+	health *health.Server
 
-	mu       sync.Mutex // guards following fields
-	services map[string]*serviceInfo
+	mu           sync.Mutex // guards following fields
+	services     map[string]*serviceInfo
+	streams      map[uint32]*serverStream
+	lastStreamID uint32
 }
 
 func NewServer(opt ...ServerOption) *Server {
@@ -52,10 +75,31 @@ func NewServer(opt ...ServerOption) *Server {
 		o.applyServer(&opts)
 	}
 
-	return &Server{
+	chainUnaryServerInterceptors(&opts)
+
+	s := &Server{
 		opts:     opts,
+		health:   health.NewServer(),
 		services: make(map[string]*serviceInfo),
+		streams:  make(map[uint32]*serverStream),
+	}
+
+	if opts.health {
+		// Registering a service on an empty server can't fail.
+		_ = s.register(&healthpb.Health_ServiceDesc, s.health)
 	}
+
+	return s
+}
+
+// SetServingStatus sets the serving status of a service, reported by the
+// health service to the host, see [WatchHealth]. The empty service name
+// denotes the status of the plugin as a whole, it is SERVING by default. It
+// has no effect if the Server was not created with [WithHealth], or if the
+// plugin registers its own implementation of the health service, which
+// replaces the built-in one.
+func (s *Server) SetServingStatus(service string, servingStatus healthpb.HealthCheckResponse_ServingStatus) {
+	s.health.SetServingStatus(service, servingStatus)
 }
 
 // RegisterService registers a service and its implementation to the gRPC
@@ -66,10 +110,6 @@ func NewServer(opt ...ServerOption) *Server {
 // If there is an error during registration, the server will log the error and
 // exit the process. This is to ensure that the plugin does not run with an
 // invalid state.
-//
-// Note that this method does not support stream RPCs. If the service
-// description contains stream methods, a warning will be logged and the stream
-// methods will be ignored.
 func (s *Server) RegisterService(sd *grpc.ServiceDesc, ss any) {
 	if ss != nil {
 		ht := reflect.TypeOf(sd.HandlerType).Elem()
@@ -94,18 +134,16 @@ func (s *Server) register(sd *grpc.ServiceDesc, ss any) error {
 
 	s.opts.logger.Debug("Registering service", "service", sd.ServiceName)
 
-	if _, ok := s.services[sd.ServiceName]; ok {
+	// The built-in health service can be replaced by a custom implementation.
+	if info, ok := s.services[sd.ServiceName]; ok && info.serviceImpl != s.health {
 		return fmt.Errorf("found duplicate service registration: %q", sd.ServiceName)
 	}
 
-	if len(sd.Streams) > 0 {
-		s.opts.logger.Warn("proto: Server.RegisterService found stream service, "+
-			"streams are not supported in Wasm plugins", "service", sd.ServiceName)
-	}
-
 	info := &serviceInfo{
 		serviceImpl: ss,
 		methods:     make(map[string]*grpc.MethodDesc),
+		streams:     make(map[string]*grpc.StreamDesc),
+		mdata:       sd.Metadata,
 	}
 
 	for i := range sd.Methods {
@@ -113,77 +151,307 @@ func (s *Server) register(sd *grpc.ServiceDesc, ss any) error {
 		info.methods[d.MethodName] = d
 	}
 
+	for i := range sd.Streams {
+		d := &sd.Streams[i]
+		info.streams[d.StreamName] = d
+	}
+
 	s.services[sd.ServiceName] = info
 
 	return nil
 }
 
+// GetServiceInfo returns a map from service names to grpc.ServiceInfo. Service
+// names include the package names, in the form of <package>.<service>.
+//
+// Together with RegisterService, it allows registering the standard gRPC
+// reflection service on the server using reflection.RegisterV1 from
+// google.golang.org/grpc/reflection, so that the host can discover the
+// services implemented by the plugin, see [ListServices]. Hornet doesn't
+// register the reflection service itself, so that plugins which don't use it
+// don't link the reflection package.
+func (s *Server) GetServiceInfo() map[string]grpc.ServiceInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ret := make(map[string]grpc.ServiceInfo, len(s.services))
+	for name, info := range s.services {
+		methods := make([]grpc.MethodInfo, 0, len(info.methods)+len(info.streams))
+		for m := range info.methods {
+			methods = append(methods, grpc.MethodInfo{Name: m})
+		}
+
+		for m, d := range info.streams {
+			methods = append(methods, grpc.MethodInfo{
+				Name:           m,
+				IsClientStream: d.ClientStreams,
+				IsServerStream: d.ServerStreams,
+			})
+		}
+
+		ret[name] = grpc.ServiceInfo{
+			Methods:  methods,
+			Metadata: info.mdata,
+		}
+	}
+
+	return ret
+}
+
 // Handle implements the [PluginHandler] interface and processes the bytes
 // sent to the plugin as a gRPC request.
 func (s *Server) Handle(fn string, reqBytes []byte) []byte {
 	// Start a new context for each request.
-	ctx := context.Background()
+	return s.handle(context.Background(), fn, reqBytes)
+}
 
-	pos := strings.LastIndex(fn, "/")
-	if pos == -1 {
+// handle processes the bytes of a gRPC request using the given context.
+func (s *Server) handle(ctx context.Context, fn string, reqBytes []byte) []byte {
+	resp, st := s.handleUnary(ctx, fn, reqBytes)
+	if st != nil {
+		return encodeError(st)
+	}
+
+	if err := s.checkSendMsgSize(resp); err != nil {
+		return s.handleError(status.Convert(err), "method", fn)
+	}
+
+	// NB: We overwrite the request bytes to reuse the same bytes buffer and
+	// possibly avoid allocations.
+	// The first byte tells the client if it's an error or a valid response.
+	respBytes, err := protoMarshalAppend(append(reqBytes[:0], responseOK), resp)
+	if err != nil {
 		return s.handleError(
-			status.New(codes.Unimplemented, "malformed method name"),
-			"method", fn,
+			status.New(codes.Internal, "error marshalling response"),
+			"method", fn, "response", resp, "error", err,
 		)
 	}
 
-	service := strings.TrimPrefix(fn[1:pos], "/")
-	method := fn[pos+1:]
+	return respBytes
+}
 
-	srv, ok := s.services[service]
-	if !ok {
-		return s.handleError(
-			status.New(codes.Unimplemented, "unknown service"),
-			"service", service,
+// handleEnvelope processes a request envelope using the given context and
+// returns a response envelope. The request header contains the method name,
+// the incoming metadata and the deadline, the request bytes contain the gRPC
+// request.
+func (s *Server) handleEnvelope(ctx context.Context, header, reqBytes []byte) []byte {
+	var hdr requestHeader
+
+	err := hdr.unmarshal(header)
+	if err != nil {
+		return s.handleErrorEnvelope(status.New(codes.Internal, "malformed request header"), nil, "error", err)
+	}
+
+	ts := &serverTransportStream{method: hdr.method}
+	ctx, cancel := newIncomingContext(ctx, &hdr, ts)
+	defer cancel()
+
+	resp, st := s.handleUnary(ctx, hdr.method, reqBytes, "call_id", hdr.callID)
+	rh := &responseHeader{header: ts.header, trailer: ts.trailer}
+
+	if st != nil {
+		return encodeErrorEnvelope(st, rh)
+	}
+
+	if err := s.checkSendMsgSize(resp); err != nil {
+		return s.handleErrorEnvelope(status.Convert(err), rh, "method", hdr.method, "call_id", hdr.callID)
+	}
+
+	// NB: We overwrite the request bytes to reuse the same bytes buffer and
+	// possibly avoid allocations.
+	respBytes, err := protoMarshalAppend(appendResponseEnvelope(reqBytes[:0], responseOK, rh), resp)
+	if err != nil {
+		return s.handleErrorEnvelope(
+			status.New(codes.Internal, "error marshalling response"), rh,
+			"method", hdr.method, "call_id", hdr.callID, "response", resp, "error", err,
 		)
 	}
 
+	return respBytes
+}
+
+// handleUnary calls the handler of the unary method and returns the response
+// message, or the status of the error returned by the handler. The log
+// arguments are added to the logged errors.
+func (s *Server) handleUnary(ctx context.Context, fn string, reqBytes []byte, logArgs ...any) (any, *status.Status) {
+	srv, service, method, st := s.lookupService(fn)
+	if st != nil {
+		s.logError(st, append([]any{"method", fn}, logArgs...)...)
+		return nil, st
+	}
+
 	sd, ok := srv.methods[method]
 	if !ok {
-		return s.handleError(
-			status.New(codes.Unimplemented, "unknown method"),
-			"service", service, "method", method,
-		)
+		st := status.New(codes.Unimplemented, "unknown method")
+		s.logError(st, append([]any{"service", service, "method", method}, logArgs...)...)
+
+		return nil, st
+	}
+
+	if err := checkRecvMsgSize(len(reqBytes), s.opts.maxRecvMsgSize); err != nil {
+		st := status.Convert(err)
+		s.logError(st, append([]any{"service", service, "method", method}, logArgs...)...)
+
+		return nil, st
 	}
 
 	decFn := func(v any) error {
 		return protoUnmarshal(reqBytes, v)
 	}
 
-	resp, err := sd.Handler(srv.serviceImpl, ctx, decFn, nil)
+	resp, err := s.callUnaryHandler(ctx, srv, sd, decFn)
 	if err != nil {
-		st, ok := status.FromError(err)
-		if !ok {
-			st = status.FromContextError(err)
-			err = st.Err()
+		st := statusFromError(err)
+		s.logError(st, append([]any{"service", service, "method", method, "error", err}, logArgs...)...)
+
+		return nil, st
+	}
+
+	return resp, nil
+}
+
+// callUnaryHandler calls the handler of a unary method. A panic in the handler
+// is recovered and returned as an error with the code Internal, so that the
+// Wasm module is not left in a broken state.
+func (s *Server) callUnaryHandler(
+	ctx context.Context,
+	srv *serviceInfo,
+	sd *grpc.MethodDesc,
+	decFn func(any) error,
+) (resp any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
 		}
+	}()
 
-		return s.handleError(st, "service", service, "method", method, "error", err)
+	return sd.Handler(srv.serviceImpl, ctx, decFn, s.opts.unaryInt)
+}
+
+// checkSendMsgSize returns an error with the code ResourceExhausted if the
+// response message is larger than the maximum send message size.
+func (s *Server) checkSendMsgSize(resp any) error {
+	msg, ok := resp.(proto.Message)
+	if !ok {
+		// Marshalling the response fails with a more descriptive error.
+		return nil
 	}
 
-	// NB: We overwrite the request bytes to reuse the same bytes buffer and
-	// possibly avoid allocations.
-	respBytes, err := protoMarshalAppend(reqBytes[:0], resp)
+	return checkSendMsgSize(proto.Size(msg), s.opts.maxSendMsgSize)
+}
+
+// newIncomingContext returns a context for handling a request with the given
+// request header. The context contains the incoming metadata and the transport
+// stream used by grpc.SetHeader, grpc.SendHeader and grpc.SetTrailer. If the
+// request header contains a timeout, the context is cancelled once it expires.
+func newIncomingContext(
+	ctx context.Context,
+	hdr *requestHeader,
+	ts *serverTransportStream,
+) (context.Context, context.CancelFunc) {
+	if hdr.metadata != nil {
+		ctx = metadata.NewIncomingContext(ctx, hdr.metadata)
+	}
+
+	ctx = grpc.NewContextWithServerTransportStream(ctx, ts)
+
+	if hdr.timeout > 0 {
+		return context.WithTimeout(ctx, hdr.timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// serverTransportStream implements grpc.ServerTransportStream. It collects the
+// header and trailer metadata set by the handler, so they can be sent to the
+// host together with the response.
+type serverTransportStream struct {
+	method     string
+	header     metadata.MD
+	trailer    metadata.MD
+	headerSent bool
+}
+
+var _ grpc.ServerTransportStream = (*serverTransportStream)(nil)
+
+func (ts *serverTransportStream) Method() string { return ts.method }
+
+func (ts *serverTransportStream) SetHeader(md metadata.MD) error {
+	if ts.headerSent {
+		return status.Error(codes.Internal, "transport: the stream is done or SendHeader was already called")
+	}
+
+	ts.header = metadata.Join(ts.header, md)
+
+	return nil
+}
+
+// SendHeader sets the header metadata. The header is sent to the host
+// together with the next response.
+func (ts *serverTransportStream) SendHeader(md metadata.MD) error {
+	err := ts.SetHeader(md)
 	if err != nil {
-		return s.handleError(
-			status.New(codes.Internal, "error marshalling response"),
-			"service", service, "method", method, "response", resp, "error", err,
-		)
+		return err
 	}
 
-	return respBytes
+	ts.headerSent = true
+
+	return nil
+}
+
+func (ts *serverTransportStream) SetTrailer(md metadata.MD) error {
+	ts.trailer = metadata.Join(ts.trailer, md)
+	return nil
+}
+
+// lookupService splits the full method name into the service and method name
+// and returns the registered service. If the method name is malformed or the
+// service is unknown, it returns an Unimplemented status.
+func (s *Server) lookupService(fn string) (*serviceInfo, string, string, *status.Status) {
+	pos := strings.LastIndex(fn, "/")
+	if pos == -1 {
+		return nil, "", "", status.New(codes.Unimplemented, "malformed method name")
+	}
+
+	service := strings.TrimPrefix(fn[1:pos], "/")
+	method := fn[pos+1:]
+
+	srv, ok := s.services[service]
+	if !ok {
+		return nil, service, method, status.New(codes.Unimplemented, "unknown service")
+	}
+
+	return srv, service, method, nil
 }
 
 func (s *Server) handleError(st *status.Status, args ...any) []byte {
+	s.logError(st, args...)
+	return encodeError(st)
+}
+
+func (s *Server) handleErrorEnvelope(st *status.Status, h *responseHeader, args ...any) []byte {
+	s.logError(st, args...)
+	return encodeErrorEnvelope(st, h)
+}
+
+func (s *Server) logError(st *status.Status, args ...any) {
 	s.opts.logger.Debug("ERROR: proto: Server.Handle "+st.Message(), args...)
+}
 
+// encodeError encodes the status as an error response.
+func encodeError(st *status.Status) []byte {
 	// The first byte tells the client if it's an error or a valid response.
-	out, err := proto.MarshalOptions{}.MarshalAppend([]byte{1}, st.Proto())
+	return appendStatus([]byte{responseError}, st)
+}
+
+// encodeErrorEnvelope encodes the status as an error response envelope with
+// the given response header.
+func encodeErrorEnvelope(st *status.Status, h *responseHeader) []byte {
+	return appendStatus(appendResponseEnvelope(nil, responseError, h), st)
+}
+
+func appendStatus(b []byte, st *status.Status) []byte {
+	out, err := proto.MarshalOptions{}.MarshalAppend(b, st.Proto())
 	if err != nil {
 		// This should never happen, as we are marshalling a status message. If it
 		// does, we panic, as we cannot return a proper error message to the client.
@@ -199,9 +467,6 @@ func protoMarshalAppend(data []byte, v any) ([]byte, error) {
 		return data, fmt.Errorf("proto: error marshalling data: expected proto.Message, got %T", v)
 	}
 
-	// The first byte tells the client if it's an error or a valid response.
-	data = append(data, 0)
-
 	data, err := proto.MarshalOptions{}.MarshalAppend(data, msg)
 	if err != nil {
 		return data, fmt.Errorf("proto: error marshalling data: %w", err)
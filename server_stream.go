@@ -0,0 +1,429 @@
+package hornet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"runtime"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// serverStream implements grpc.ServerStream. The stream handler runs in its own
+// goroutine. Messages sent by the host are queued until the handler receives
+// them, and each message sent by the handler is handed over to the host, which
+// pulls the messages one by one.
+type serverStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// ts collects the header and trailer metadata set by the handler.
+	ts *serverTransportStream
+	// maxSendMsgSize is the maximum size of a message sent by the handler.
+	maxSendMsgSize int
+
+	mu sync.Mutex // guards following fields
+	// headerDelivered is true once the header was sent to the host.
+	headerDelivered bool
+	// in contains messages sent by the host that were not yet received by
+	// the handler.
+	in [][]byte
+	// inClosed is true once the host closed the request stream.
+	inClosed bool
+
+	// wake is signalled when a message is added to in or the request stream
+	// is closed.
+	wake chan struct{}
+	// wantInput is signalled when the handler is blocked waiting for the
+	// next message from the host.
+	wantInput chan struct{}
+
+	// out receives messages sent by the handler, marshalled as response
+	// envelopes.
+	out chan []byte
+	// done is closed when the handler returns.
+	done chan struct{}
+	// err is the error returned by the handler, it is safe to read after done
+	// is closed.
+	err error
+}
+
+var _ grpc.ServerStream = (*serverStream)(nil)
+
+func newServerStream(hdr *requestHeader, maxSendMsgSize int) *serverStream {
+	ts := &serverTransportStream{method: hdr.method}
+	ctx, cancel := newIncomingContext(context.Background(), hdr, ts)
+
+	return &serverStream{
+		ctx:            ctx,
+		cancel:         cancel,
+		ts:             ts,
+		maxSendMsgSize: maxSendMsgSize,
+		wake:           make(chan struct{}, 1),
+		wantInput:      make(chan struct{}, 1),
+		out:            make(chan []byte),
+		done:           make(chan struct{}),
+	}
+}
+
+func (ss *serverStream) SetHeader(md metadata.MD) error { return ss.ts.SetHeader(md) }
+
+// SendHeader sets the header metadata. The header is sent to the host
+// together with the next message or at the end of the stream.
+func (ss *serverStream) SendHeader(md metadata.MD) error { return ss.ts.SendHeader(md) }
+
+func (ss *serverStream) SetTrailer(md metadata.MD) { _ = ss.ts.SetTrailer(md) }
+
+func (ss *serverStream) Context() context.Context { return ss.ctx }
+
+// SendMsg marshals m and blocks until the host pulls it from the stream. The
+// first message carries the header metadata.
+func (ss *serverStream) SendMsg(m any) error {
+	if msg, ok := m.(proto.Message); ok {
+		if err := checkSendMsgSize(proto.Size(msg), ss.maxSendMsgSize); err != nil {
+			return err
+		}
+	}
+
+	msg, err := protoMarshalAppend(appendResponseEnvelope(nil, responseOK, ss.takeHeader()), m)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case ss.out <- msg:
+		return nil
+	case <-ss.ctx.Done():
+		return status.FromContextError(ss.ctx.Err()).Err()
+	}
+}
+
+// RecvMsg blocks until the host sends the next message and unmarshals it into
+// m. It returns io.EOF once the host closed the request stream and all
+// messages were received.
+func (ss *serverStream) RecvMsg(m any) error {
+	for {
+		msg, ok, closed := ss.pop()
+		if ok {
+			return protoUnmarshal(msg, m)
+		}
+
+		if closed {
+			return io.EOF
+		}
+
+		notify(ss.wantInput)
+
+		select {
+		case <-ss.wake:
+		case <-ss.ctx.Done():
+			return status.FromContextError(ss.ctx.Err()).Err()
+		}
+	}
+}
+
+// pop removes the next message sent by the host from the request stream. If
+// there is none, ok is false and closed reports whether the request stream is
+// closed.
+func (ss *serverStream) pop() (msg []byte, ok, closed bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if len(ss.in) == 0 {
+		return nil, false, ss.inClosed
+	}
+
+	msg = ss.in[0]
+	ss.in[0] = nil
+	ss.in = ss.in[1:]
+
+	return msg, true, false
+}
+
+// takeHeader returns the response header containing the header metadata, if
+// it was not delivered yet. Once called, the header can't be changed anymore.
+func (ss *serverStream) takeHeader() *responseHeader {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.ts.headerSent = true
+
+	if ss.headerDelivered {
+		return nil
+	}
+
+	ss.headerDelivered = true
+
+	return &responseHeader{header: ss.ts.header}
+}
+
+// push adds a message sent by the host to the request stream. It returns false
+// if the request stream is already closed.
+func (ss *serverStream) push(msg []byte) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.inClosed {
+		return false
+	}
+
+	ss.in = append(ss.in, msg)
+	ss.wakeUp()
+
+	return true
+}
+
+// closeSend closes the request stream.
+func (ss *serverStream) closeSend() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.inClosed = true
+	ss.wakeUp()
+}
+
+// wakeUp wakes up the handler if it's waiting for input. Any pending request
+// for input is dropped, as the handler can now make progress.
+func (ss *serverStream) wakeUp() {
+	select {
+	case <-ss.wantInput:
+	default:
+	}
+
+	notify(ss.wake)
+}
+
+// run calls the stream handler and closes done once it returns. A panic in the
+// handler is recovered and ends the stream with the code Internal.
+func (ss *serverStream) run(srv *serviceInfo, sd *grpc.StreamDesc) {
+	defer close(ss.done)
+	defer ss.cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			ss.err = panicError(r)
+		}
+	}()
+
+	ss.err = sd.Handler(srv.serviceImpl, ss)
+}
+
+// notify does a non-blocking send to a channel with a buffer of size 1.
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// openStream starts the stream handler for the method in the request header in
+// a new goroutine and returns the ID of the stream as the payload of a response
+// envelope. If the method is a server-streaming RPC, reqBytes contains the
+// request message and the request stream is closed right away. Otherwise
+// reqBytes is ignored and the host is expected to send messages using
+// sendStream.
+func (s *Server) openStream(header, reqBytes []byte) []byte {
+	var hdr requestHeader
+
+	err := hdr.unmarshal(header)
+	if err != nil {
+		return s.handleErrorEnvelope(status.New(codes.Internal, "malformed request header"), nil, "error", err)
+	}
+
+	srv, service, method, st := s.lookupService(hdr.method)
+	if st != nil {
+		return s.handleErrorEnvelope(st, nil, "method", hdr.method, "call_id", hdr.callID)
+	}
+
+	sd, ok := srv.streams[method]
+	if !ok {
+		return s.handleErrorEnvelope(
+			status.New(codes.Unimplemented, "unknown method"), nil,
+			"service", service, "method", method, "call_id", hdr.callID,
+		)
+	}
+
+	if err := checkRecvMsgSize(len(reqBytes), s.opts.maxRecvMsgSize); err != nil {
+		return s.handleErrorEnvelope(
+			status.Convert(err), nil,
+			"service", service, "method", method, "call_id", hdr.callID,
+		)
+	}
+
+	ss := newServerStream(&hdr, s.opts.maxSendMsgSize)
+	if !sd.ClientStreams {
+		// The request bytes point to a buffer that is reused by the next
+		// call, the handler needs its own copy.
+		ss.push(bytes.Clone(reqBytes))
+		ss.closeSend()
+	}
+
+	s.mu.Lock()
+	s.lastStreamID++
+	id := s.lastStreamID
+	s.streams[id] = ss
+	s.mu.Unlock()
+
+	go ss.run(srv, sd)
+
+	return binary.LittleEndian.AppendUint32(appendResponseEnvelope(nil, responseOK, nil), id)
+}
+
+// sendStream adds a message to the request stream of the stream with the given
+// ID. If the handler already returned, the message is dropped and the returned
+// response signals the end of the stream, the host can discover the status of
+// the stream using recvStream.
+func (s *Server) sendStream(id uint32, reqBytes []byte) []byte {
+	ss, st := s.getStream(id)
+	if st != nil {
+		return s.handleErrorEnvelope(st, nil, "stream", id)
+	}
+
+	select {
+	case <-ss.done:
+		return appendResponseEnvelope(nil, responseEOF, nil)
+	default:
+	}
+
+	if err := checkRecvMsgSize(len(reqBytes), s.opts.maxRecvMsgSize); err != nil {
+		return s.handleErrorEnvelope(status.Convert(err), nil, "stream", id)
+	}
+
+	// The request bytes point to a buffer that is reused by the next call, the
+	// handler needs its own copy.
+	if !ss.push(bytes.Clone(reqBytes)) {
+		return s.handleErrorEnvelope(
+			status.New(codes.FailedPrecondition, "send on closed request stream"), nil,
+			"stream", id,
+		)
+	}
+
+	return appendResponseEnvelope(nil, responseOK, nil)
+}
+
+// closeSendStream closes the request stream of the stream with the given ID.
+// Closing an unknown stream is a no-op.
+func (s *Server) closeSendStream(id uint32) {
+	ss, st := s.getStream(id)
+	if st == nil {
+		ss.closeSend()
+	}
+}
+
+// recvStreamYields is the number of times recvStream yields to other
+// goroutines, giving the stream handler a chance to make progress, before it
+// reports that the stream is pending.
+const recvStreamYields = 8
+
+// recvStream returns the next message sent by the handler of the stream with
+// the given ID, a request for input if the handler waits for the next message
+// from the host, or the end of the stream. Once the handler returns, the stream
+// is released and the returned response contains either the error returned by
+// the handler or the end of the stream, together with the trailer metadata.
+//
+// The goroutines of the Wasm module only run while the host calls into it, so
+// recvStream must not block. If the handler waits on anything else, e.g. a
+// timer or its context, blocking would leave no goroutine that can make
+// progress and the Go runtime would crash. Instead, a pending response is
+// returned and the host tries again later.
+func (s *Server) recvStream(id uint32) []byte {
+	ss, st := s.getStream(id)
+	if st != nil {
+		return s.handleErrorEnvelope(st, nil, "stream", id)
+	}
+
+	for i := 0; ; i++ {
+		select {
+		case msg := <-ss.out:
+			return msg
+		case <-ss.wantInput:
+			select {
+			case <-ss.done:
+				// The handler returned after requesting input.
+				return s.endStream(id, ss)
+			default:
+				return appendResponseEnvelope(nil, responseNeedInput, nil)
+			}
+		case <-ss.done:
+			return s.endStream(id, ss)
+		default:
+		}
+
+		if i == recvStreamYields {
+			return appendResponseEnvelope(nil, responsePending, nil)
+		}
+
+		runtime.Gosched()
+	}
+}
+
+// endStream releases the stream whose handler returned and returns the last
+// response of the stream, containing the trailer metadata.
+func (s *Server) endStream(id uint32, ss *serverStream) []byte {
+	s.releaseStream(id)
+
+	rh := ss.takeHeader()
+	if rh == nil {
+		rh = &responseHeader{}
+	}
+
+	rh.trailer = ss.ts.trailer
+
+	if ss.err != nil {
+		return s.handleErrorEnvelope(statusFromError(ss.err), rh, "stream", id, "error", ss.err)
+	}
+
+	return appendResponseEnvelope(nil, responseEOF, rh)
+}
+
+// closeStream cancels the context of the stream with the given ID and releases
+// the stream. Closing an unknown stream is a no-op.
+//
+// The handler goroutine only runs while the host calls into the Wasm module, so
+// closeStream yields to it, giving it a chance to return before the call ends.
+// Like in recvStream, it must not block, a handler that ignores its context
+// keeps running until the next call.
+func (s *Server) closeStream(id uint32) {
+	ss := s.releaseStream(id)
+	if ss == nil {
+		return
+	}
+
+	ss.cancel()
+
+	for range recvStreamYields {
+		select {
+		case <-ss.done:
+			return
+		default:
+		}
+
+		runtime.Gosched()
+	}
+}
+
+func (s *Server) getStream(id uint32) (*serverStream, *status.Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss, ok := s.streams[id]
+	if !ok {
+		return nil, status.New(codes.Internal, "unknown stream")
+	}
+
+	return ss, nil
+}
+
+func (s *Server) releaseStream(id uint32) *serverStream {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ss := s.streams[id]
+	delete(s.streams, id)
+
+	return ss
+}
@@ -0,0 +1,178 @@
+package hornet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/matryer/is"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var _ reflection.GRPCServer = (*Server)(nil)
+
+func TestServer_GetServiceInfo(t *testing.T) {
+	is := is.New(t)
+
+	srv := NewServer()
+	reflection.RegisterV1(srv)
+
+	info := srv.GetServiceInfo()
+	is.Equal(len(info), 1)
+
+	svc, ok := info["grpc.reflection.v1.ServerReflection"]
+	is.True(ok)
+	is.Equal(svc.Methods, []grpc.MethodInfo{{
+		Name:           "ServerReflectionInfo",
+		IsClientStream: true,
+		IsServerStream: true,
+	}})
+	is.Equal(svc.Metadata, "grpc/reflection/v1/reflection.proto")
+}
+
+func TestServer_CallUnaryHandler(t *testing.T) {
+	t.Run("should recover panic in handler", func(t *testing.T) {
+		is := is.New(t)
+
+		srv := NewServer()
+		sd := &grpc.MethodDesc{
+			MethodName: "Panic",
+			Handler: func(any, context.Context, func(any) error, grpc.UnaryServerInterceptor) (any, error) {
+				panic("boom")
+			},
+		}
+
+		_, err := srv.callUnaryHandler(context.Background(), &serviceInfo{}, sd, nil)
+		st := status.Convert(err)
+		is.Equal(st.Code(), codes.Internal)
+		is.Equal(st.Message(), "panic in handler: boom")
+
+		is.Equal(len(st.Details()), 1)
+		info, ok := st.Details()[0].(*errdetails.DebugInfo)
+		is.True(ok)
+		is.Equal(info.GetDetail(), "boom")
+		is.True(len(info.GetStackEntries()) > 0)
+	})
+}
+
+// streamTestService implements the streams of the test service, so that
+// streams can be tested without a Wasm module.
+type streamTestService struct {
+	testsvc.UnimplementedTestServiceServer
+}
+
+// Count waits until the stream is closed.
+func (streamTestService) Count(
+	_ *wrapperspb.Int64Value,
+	stream grpc.ServerStreamingServer[wrapperspb.Int64Value],
+) error {
+	<-stream.Context().Done()
+	return status.FromContextError(stream.Context().Err()).Err()
+}
+
+// Sum returns the sum of the received numbers.
+func (streamTestService) Sum(stream grpc.ClientStreamingServer[wrapperspb.Int64Value, wrapperspb.Int64Value]) error {
+	var sum int64
+
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(wrapperspb.Int64(sum))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		sum += msg.GetValue()
+	}
+}
+
+func TestServer_Stream(t *testing.T) {
+	// openStream opens a stream of the method and returns its ID.
+	openStream := func(is *is.I, srv *Server, method string, req proto.Message) uint32 {
+		reqBytes, err := proto.Marshal(req)
+		is.NoErr(err)
+
+		hdr := requestHeader{method: method}
+		typ, _, payload, err := parseResponseEnvelope(srv.openStream(hdr.appendTo(nil), reqBytes))
+		is.NoErr(err)
+		is.Equal(typ, responseOK)
+
+		return binary.LittleEndian.Uint32(payload)
+	}
+
+	t.Run("should receive messages sent concurrently", func(t *testing.T) {
+		is := is.New(t)
+
+		srv := NewServer()
+		testsvc.RegisterTestServiceServer(srv, streamTestService{})
+
+		id := openStream(is, srv, testsvc.SumFullMethodName, nil)
+
+		var wg sync.WaitGroup
+		for i := range int64(10) {
+			msg, err := proto.Marshal(wrapperspb.Int64(i))
+			is.NoErr(err)
+
+			wg.Go(func() {
+				typ, _, _, err := parseResponseEnvelope(srv.sendStream(id, msg))
+				is.NoErr(err)
+				is.Equal(typ, responseOK)
+			})
+		}
+
+		wg.Wait()
+		srv.closeSendStream(id)
+
+		var sum wrapperspb.Int64Value
+
+		for {
+			typ, _, payload, err := parseResponseEnvelope(srv.recvStream(id))
+			is.NoErr(err)
+
+			if typ == responseOK {
+				is.NoErr(proto.Unmarshal(payload, &sum))
+				break
+			}
+		}
+
+		is.Equal(sum.GetValue(), int64(45))
+	})
+
+	t.Run("should return cancelled handler when stream is closed", func(t *testing.T) {
+		is := is.New(t)
+
+		// Run goroutines one at a time, like in a Wasm module.
+		defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+
+		srv := NewServer()
+		testsvc.RegisterTestServiceServer(srv, streamTestService{})
+
+		id := openStream(is, srv, testsvc.CountFullMethodName, wrapperspb.Int64(1))
+		ss := srv.streams[id]
+
+		typ, _, _, err := parseResponseEnvelope(srv.recvStream(id))
+		is.NoErr(err)
+		is.Equal(typ, responsePending)
+
+		srv.closeStream(id)
+
+		select {
+		case <-ss.done:
+		default:
+			is.Fail() // handler did not return
+		}
+	})
+}
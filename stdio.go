@@ -0,0 +1,234 @@
+package hornet
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// maxStdioLineSize is the maximum size of a line written by the Wasm module to
+// stdout or stderr. Longer lines are split, so that a module that never writes
+// a newline does not grow the buffer indefinitely.
+const maxStdioLineSize = 64 * 1024
+
+// stdioOptions configures where the stdout and stderr of a module instantiated
+// by InstantiateModuleAndClient are written.
+type stdioOptions struct {
+	stdout io.Writer
+	stderr io.Writer
+	prefix string
+	// log is true if the lines are logged instead of written to stdout and
+	// stderr, stdoutLevel and stderrLevel are the levels of the records.
+	log         bool
+	stdoutLevel slog.Level
+	stderrLevel slog.Level
+	// method is true if lines are tagged with the method of the call during
+	// which they were written.
+	method bool
+}
+
+// lines returns true if the output needs to be split into lines.
+func (o stdioOptions) lines() bool {
+	return o.prefix != "" || o.log || o.method
+}
+
+// moduleOutput is the stdout and stderr of a module instantiated by Hornet.
+type moduleOutput struct {
+	stdout *outputWriter
+	stderr *outputWriter
+	// method is the method of the call in progress, it's only set if lines are
+	// tagged with methods.
+	method atomic.Pointer[string]
+	track  bool
+}
+
+func newModuleOutput(opts stdioOptions, logger *slog.Logger) *moduleOutput {
+	o := &moduleOutput{track: opts.method}
+
+	o.stdout = &outputWriter{
+		out:    o,
+		w:      opts.stdout,
+		stream: "stdout",
+		level:  opts.stdoutLevel,
+		prefix: opts.prefix,
+		lines:  opts.lines(),
+	}
+	o.stderr = &outputWriter{
+		out:    o,
+		w:      opts.stderr,
+		stream: "stderr",
+		level:  opts.stderrLevel,
+		prefix: opts.prefix,
+		lines:  opts.lines(),
+	}
+
+	if opts.log {
+		o.stdout.logger = logger
+		o.stderr.logger = logger
+	}
+
+	return o
+}
+
+// begin is called before calling into the module. Lines written until end is
+// called are tagged with the method, if enabled.
+func (o *moduleOutput) begin(method string) {
+	if o.track {
+		o.method.Store(&method)
+	}
+}
+
+// end is called after the call into the module returned. If lines are tagged
+// with methods, incomplete lines are flushed, so they are tagged with the
+// method of the call that wrote them.
+func (o *moduleOutput) end() {
+	if o.track {
+		o.flush()
+		o.method.Store(nil)
+	}
+}
+
+// flush writes incomplete lines of both streams.
+func (o *moduleOutput) flush() {
+	o.stdout.flush()
+	o.stderr.flush()
+}
+
+// currentMethod returns the method of the call in progress, or an empty
+// string if it's not known.
+func (o *moduleOutput) currentMethod() string {
+	if m := o.method.Load(); m != nil {
+		return *m
+	}
+
+	return ""
+}
+
+// outputWriter is the writer passed to the module as stdout or stderr. It is
+// not an *os.File on purpose, so that wazero doesn't change the flags of the
+// host's file descriptors when the module configures its stdio.
+type outputWriter struct {
+	out    *moduleOutput
+	w      io.Writer
+	stream string
+	logger *slog.Logger
+	level  slog.Level
+	prefix string
+	// lines is true if the output is split into lines, otherwise it's written
+	// to w as is.
+	lines bool
+
+	mu sync.Mutex // guards following fields
+	// buf contains the incomplete last line.
+	buf []byte
+	// lineBuf is the buffer used to build lines written to w.
+	lineBuf []byte
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	if w.w == nil && w.logger == nil {
+		return len(p), nil
+	}
+
+	if !w.lines {
+		return w.w.Write(p)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+
+	rest := w.buf
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+
+		err := w.emit(rest[:i])
+		if err != nil {
+			w.buf = w.buf[:0]
+			return 0, err
+		}
+
+		rest = rest[i+1:]
+	}
+
+	for len(rest) >= maxStdioLineSize {
+		err := w.emit(rest[:maxStdioLineSize])
+		if err != nil {
+			w.buf = w.buf[:0]
+			return 0, err
+		}
+
+		rest = rest[maxStdioLineSize:]
+	}
+
+	w.buf = append(w.buf[:0], rest...)
+
+	return len(p), nil
+}
+
+// flush writes the incomplete last line, if any.
+func (w *outputWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		_ = w.emit(w.buf)
+		w.buf = w.buf[:0]
+	}
+}
+
+// emit writes a single line without the trailing newline. The caller must
+// hold the lock.
+func (w *outputWriter) emit(line []byte) error {
+	method := w.out.currentMethod()
+
+	if w.logger != nil {
+		attrs := []slog.Attr{slog.String("stream", w.stream)}
+		if method != "" {
+			attrs = append(attrs, slog.String("method", method))
+		}
+
+		msg := string(bytes.TrimSuffix(line, []byte{'\r'}))
+		if w.prefix != "" {
+			msg = w.prefix + msg
+		}
+
+		w.logger.LogAttrs(context.Background(), w.level, msg, attrs...)
+
+		return nil
+	}
+
+	w.lineBuf = append(w.lineBuf[:0], w.prefix...)
+	if method != "" {
+		w.lineBuf = append(w.lineBuf, '[')
+		w.lineBuf = append(w.lineBuf, method...)
+		w.lineBuf = append(w.lineBuf, "] "...)
+	}
+
+	w.lineBuf = append(w.lineBuf, line...)
+	w.lineBuf = append(w.lineBuf, '\n')
+
+	_, err := w.w.Write(w.lineBuf)
+
+	return err
+}
+
+type callMethodCtxKey struct{}
+
+// contextWithCallMethod stores the method of the call in the context, so that
+// ClientConn.call can tag the output of the module with it.
+func contextWithCallMethod(ctx context.Context, method string) context.Context {
+	return context.WithValue(ctx, callMethodCtxKey{}, method)
+}
+
+func callMethodFromContext(ctx context.Context) string {
+	method, _ := ctx.Value(callMethodCtxKey{}).(string)
+	return method
+}
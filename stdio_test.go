@@ -0,0 +1,104 @@
+package hornet
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestModuleOutput(t *testing.T) {
+	t.Run("should write output as is without line options", func(t *testing.T) {
+		is := is.New(t)
+		var stdout, stderr bytes.Buffer
+		out := newModuleOutput(stdioOptions{stdout: &stdout, stderr: &stderr}, slog.Default())
+
+		_, err := out.stdout.Write([]byte("partial"))
+		is.NoErr(err)
+		_, err = out.stderr.Write([]byte("error\n"))
+		is.NoErr(err)
+
+		is.Equal(stdout.String(), "partial")
+		is.Equal(stderr.String(), "error\n")
+	})
+
+	t.Run("should discard output written to nil writer", func(t *testing.T) {
+		is := is.New(t)
+		out := newModuleOutput(stdioOptions{prefix: "p: "}, slog.Default())
+
+		n, err := out.stdout.Write([]byte("line\n"))
+		is.NoErr(err)
+		is.Equal(n, 5)
+	})
+
+	t.Run("should prefix lines and flush incomplete line", func(t *testing.T) {
+		is := is.New(t)
+		var stdout bytes.Buffer
+		out := newModuleOutput(stdioOptions{stdout: &stdout, prefix: "[plugin] "}, slog.Default())
+
+		_, err := out.stdout.Write([]byte("first\nsec"))
+		is.NoErr(err)
+		_, err = out.stdout.Write([]byte("ond\nthird"))
+		is.NoErr(err)
+		is.Equal(stdout.String(), "[plugin] first\n[plugin] second\n")
+
+		out.flush()
+		is.Equal(stdout.String(), "[plugin] first\n[plugin] second\n[plugin] third\n")
+	})
+
+	t.Run("should split long lines", func(t *testing.T) {
+		is := is.New(t)
+		var stdout bytes.Buffer
+		out := newModuleOutput(stdioOptions{stdout: &stdout, prefix: "> "}, slog.Default())
+
+		_, err := out.stdout.Write(bytes.Repeat([]byte{'a'}, maxStdioLineSize+1))
+		is.NoErr(err)
+		is.Equal(stdout.Len(), len("> ")+maxStdioLineSize+1)
+
+		out.flush()
+		is.True(strings.HasSuffix(stdout.String(), "\n> a\n"))
+	})
+
+	t.Run("should tag lines with method of the call", func(t *testing.T) {
+		is := is.New(t)
+		var stdout bytes.Buffer
+		out := newModuleOutput(stdioOptions{stdout: &stdout, method: true}, slog.Default())
+
+		_, err := out.stdout.Write([]byte("init\n"))
+		is.NoErr(err)
+
+		out.begin("/test.Service/Method")
+		_, err = out.stdout.Write([]byte("line\nincomplete"))
+		is.NoErr(err)
+		out.end()
+
+		is.Equal(stdout.String(), "init\n[/test.Service/Method] line\n[/test.Service/Method] incomplete\n")
+	})
+
+	t.Run("should log lines", func(t *testing.T) {
+		is := is.New(t)
+		var logs bytes.Buffer
+		logger := slog.New(slog.NewJSONHandler(&logs, nil))
+		out := newModuleOutput(stdioOptions{
+			log:         true,
+			stdoutLevel: slog.LevelInfo,
+			stderrLevel: slog.LevelError,
+			method:      true,
+		}, logger)
+
+		out.begin("/test.Service/Method")
+		_, err := out.stderr.Write([]byte("failed\r\n"))
+		is.NoErr(err)
+		out.end()
+
+		var got map[string]any
+		is.NoErr(json.Unmarshal(logs.Bytes(), &got))
+		is.Equal(got["level"], "ERROR")
+		is.Equal(got["msg"], "failed")
+		is.Equal(got["stream"], "stderr")
+		is.Equal(got["method"], "/test.Service/Method")
+	})
+}
@@ -0,0 +1,414 @@
+package hornet
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type supervisorOptions struct {
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	maxRestarts   int
+	restartWindow time.Duration
+	retryPolicy   func(method string, err error) bool
+	onRestart     func(RestartEvent)
+	clientOpts    []ClientOption
+	moduleConfig  wazero.ModuleConfig
+}
+
+func defaultSupervisorOptions() supervisorOptions {
+	return supervisorOptions{
+		minBackoff:    100 * time.Millisecond,
+		maxBackoff:    10 * time.Second,
+		maxRestarts:   5,
+		restartWindow: time.Minute,
+	}
+}
+
+var _ grpc.ClientConnInterface = (*Supervisor)(nil)
+
+var (
+	// ErrRestartBudgetExceeded is returned by calls on a [Supervisor] that
+	// stopped restarting the Wasm module, because it was restarted too many
+	// times, see [WithSupervisorRestartBudget]. The error has the code
+	// Unavailable.
+	ErrRestartBudgetExceeded = status.Error(codes.Unavailable, "Wasm module restart budget exceeded")
+
+	// errSupervisorClosed is returned by calls on a closed Supervisor.
+	errSupervisorClosed = status.Error(codes.Unavailable, "supervisor is closed")
+)
+
+// RestartEvent describes an attempt of a [Supervisor] to restart the Wasm
+// module, see [WithSupervisorOnRestart].
+type RestartEvent struct {
+	// Restart is the number of the restart, starting with 1.
+	Restart int
+	// Cause is the error returned by the call during which the previous
+	// instance died, nil if it's not known, e.g. because the module was closed
+	// outside of a call.
+	Cause error
+	// Err is the error that prevented the restart, nil if the module was
+	// restarted.
+	Err error
+	// GaveUp is true if the restart budget was exceeded and the supervisor
+	// stopped restarting the module.
+	GaveUp bool
+}
+
+// Supervisor is a connection to a Wasm module that is re-instantiated when it
+// dies, e.g. because it trapped, exited or exceeded its memory limit, or
+// because a call was interrupted. It can be passed to gRPC client constructors
+// generated by protoc-gen-go-grpc, the same way as a [ClientConn].
+//
+// The module is compiled once and instantiated with the same configuration on
+// every restart. A dead instance is detected when a call fails, and the module
+// is restarted on the next call. Consecutive restarts without a successful
+// call in between are delayed with an exponential backoff, see
+// [WithSupervisorBackoff], and the number of restarts is limited, see
+// [WithSupervisorRestartBudget]. The call during which the instance died fails,
+// unless it's retried according to [WithSupervisorRetryPolicy].
+//
+// The state of the plugin is lost on restart, plugins must not rely on state
+// kept between calls.
+type Supervisor struct {
+	opts     supervisorOptions
+	logger   *slog.Logger
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	config   wazero.ModuleConfig
+	// restartSem guards restarts of the module. It is a channel with a buffer
+	// of size 1 instead of a mutex, so that waiting for it can be interrupted
+	// by the context.
+	restartSem chan struct{}
+
+	mu   sync.Mutex // guards following fields
+	conn *ClientConn
+	// cause is the error that killed conn.
+	cause error
+	// restarts contains the times of the restarts in the restart window.
+	restarts []time.Time
+	// totalRestarts is the number of restarts since the supervisor was
+	// created.
+	totalRestarts int
+	// failedRestarts is the number of restarts without a successful call in
+	// between, used to compute the backoff.
+	failedRestarts int
+	lastRestart    time.Time
+	gaveUp         bool
+	closed         bool
+}
+
+// NewSupervisor compiles the Wasm module from the given source once and
+// instantiates it. The module is configured the same way as in
+// [InstantiateModuleAndClient], unless a configuration is passed using
+// [WithSupervisorModuleConfig]. Client options can be passed using
+// [WithSupervisorClientOptions].
+//
+// Restarts reuse the compiled module. The supervisor owns it and closes it when
+// it's closed, so there is no variant taking a wazero.CompiledModule. Each
+// supervisor compiles the module again, unless the runtime is configured with
+// a compilation cache, see [NewCompilationCache].
+//
+// The supervisor must be closed by the caller when no longer needed.
+func NewSupervisor(
+	ctx context.Context,
+	runtime wazero.Runtime,
+	source []byte,
+	opt ...SupervisorOption,
+) (*Supervisor, error) {
+	opts := defaultSupervisorOptions()
+	for _, o := range opt {
+		o.applySupervisor(&opts)
+	}
+
+	if opts.maxRestarts < 0 || opts.minBackoff < 0 || opts.maxBackoff < opts.minBackoff {
+		return nil, errors.New("invalid supervisor options")
+	}
+
+	clientOpts := defaultClientOptions
+	for _, o := range opts.clientOpts {
+		o.applyClient(&clientOpts)
+	}
+
+	config := opts.moduleConfig
+	if config == nil {
+		config = newModuleConfig()
+	}
+
+	compiled, err := runtime.CompileModule(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile Wasm module: %w", err)
+	}
+
+	s := &Supervisor{
+		opts:       opts,
+		logger:     clientOpts.logger,
+		runtime:    runtime,
+		compiled:   compiled,
+		config:     config,
+		restartSem: make(chan struct{}, 1),
+	}
+
+	_, s.conn, err = instantiateClient(ctx, runtime, compiled, s.config, opts.clientOpts)
+	if err != nil {
+		_ = compiled.Close(ctx)
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Invoke performs a unary RPC on the current instance of the Wasm module,
+// restarting the module if the instance died. If the instance dies during the
+// call, the call is retried on a new instance if the retry policy allows it.
+// See [ClientConn.Invoke] for details.
+func (s *Supervisor) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	// callErr is the error of the previous attempt, it is returned if the
+	// call can't be retried because the module can't be restarted.
+	var callErr error
+
+	for {
+		conn, err := s.acquire(ctx)
+		if err != nil {
+			return cmp.Or(callErr, err)
+		}
+
+		err = conn.Invoke(ctx, method, args, reply, opts...)
+		if !s.done(conn, err) {
+			return err
+		}
+
+		callErr = err
+
+		if ctx.Err() != nil || s.opts.retryPolicy == nil || !s.opts.retryPolicy(method, err) {
+			return err
+		}
+
+		s.logger.DebugContext(ctx, "retrying call after Wasm module died", "method", method, "error", err)
+	}
+}
+
+// NewStream begins a streaming RPC on the current instance of the Wasm module,
+// restarting the module if the instance died. Streams are not retried, if the
+// instance dies during the stream, the module is restarted on the next call.
+// See [ClientConn.NewStream] for details.
+func (s *Supervisor) NewStream(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	method string,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	conn, err := s.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := conn.NewStream(ctx, desc, method, opts...)
+	s.done(conn, err)
+
+	return cs, err
+}
+
+// Close closes the current instance and the compiled module. Calls after Close
+// fail with the code Unavailable.
+func (s *Supervisor) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.closed = true
+	conn := s.conn
+	s.mu.Unlock()
+
+	return errors.Join(conn.module.Close(ctx), s.compiled.Close(ctx))
+}
+
+// acquire returns the current instance, restarting the module if the instance
+// died.
+func (s *Supervisor) acquire(ctx context.Context) (*ClientConn, error) {
+	conn, err := s.current()
+	if conn != nil || err != nil {
+		return conn, err
+	}
+
+	select {
+	case s.restartSem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	defer func() { <-s.restartSem }()
+
+	// Another call may have restarted the module while waiting.
+	conn, err = s.current()
+	if conn != nil || err != nil {
+		return conn, err
+	}
+
+	return s.restart(ctx)
+}
+
+// current returns the current instance, or nil if it died.
+func (s *Supervisor) current() (*ClientConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case s.closed:
+		return nil, errSupervisorClosed
+	case !s.conn.module.IsClosed():
+		return s.conn, nil
+	case s.gaveUp:
+		return nil, ErrRestartBudgetExceeded
+	default:
+		return nil, nil
+	}
+}
+
+// restart instantiates the module to replace the dead instance. The caller
+// must hold restartSem.
+func (s *Supervisor) restart(ctx context.Context) (*ClientConn, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	s.restarts = slices.DeleteFunc(s.restarts, func(t time.Time) bool {
+		return now.Sub(t) >= s.opts.restartWindow
+	})
+
+	event := RestartEvent{Restart: s.totalRestarts + 1, Cause: s.cause}
+
+	if len(s.restarts) >= s.opts.maxRestarts {
+		s.gaveUp = true
+		s.mu.Unlock()
+
+		event.Err = ErrRestartBudgetExceeded
+		event.GaveUp = true
+		s.report(ctx, event)
+
+		return nil, ErrRestartBudgetExceeded
+	}
+
+	wait := s.backoff() - now.Sub(s.lastRestart)
+	s.totalRestarts++
+	s.restarts = append(s.restarts, now)
+	s.failedRestarts++
+	s.mu.Unlock()
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+
+	module, conn, err := instantiateClient(ctx, s.runtime, s.compiled, s.config, s.opts.clientOpts)
+
+	s.mu.Lock()
+	if s.closed {
+		// The supervisor was closed while restarting, nobody else is going
+		// to close the new instance.
+		s.mu.Unlock()
+
+		if err == nil {
+			_ = module.Close(ctx)
+		}
+
+		return nil, errSupervisorClosed
+	}
+
+	s.lastRestart = time.Now()
+	if err == nil {
+		s.conn = conn
+		s.cause = nil
+	}
+	s.mu.Unlock()
+
+	if err != nil {
+		event.Err = err
+		s.report(ctx, event)
+
+		return nil, status.Errorf(codes.Unavailable, "failed to restart Wasm module: %v", err)
+	}
+
+	s.report(ctx, event)
+
+	return conn, nil
+}
+
+// backoff returns the minimum time between the last and the next restart. The
+// caller must hold s.mu.
+func (s *Supervisor) backoff() time.Duration {
+	if s.failedRestarts == 0 {
+		return 0
+	}
+
+	d := s.opts.minBackoff
+	for range s.failedRestarts - 1 {
+		d *= 2
+		if d >= s.opts.maxBackoff {
+			return s.opts.maxBackoff
+		}
+	}
+
+	return d
+}
+
+// done records the result of a call on the instance and returns true if the
+// instance died during the call.
+func (s *Supervisor) done(conn *ClientConn, err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if conn != s.conn {
+		return conn.module.IsClosed()
+	}
+
+	if !conn.module.IsClosed() {
+		if err == nil {
+			// The module works again, reset the backoff.
+			s.failedRestarts = 0
+		}
+
+		return false
+	}
+
+	if s.cause == nil {
+		s.cause = err
+	}
+
+	return true
+}
+
+// report logs the restart event and passes it to the function set using
+// WithSupervisorOnRestart.
+func (s *Supervisor) report(ctx context.Context, event RestartEvent) {
+	switch {
+	case event.GaveUp:
+		s.logger.ErrorContext(ctx, "Wasm module restart budget exceeded, not restarting it anymore",
+			"restart", event.Restart, "cause", event.Cause)
+	case event.Err != nil:
+		s.logger.ErrorContext(ctx, "failed to restart Wasm module",
+			"restart", event.Restart, "cause", event.Cause, "error", event.Err)
+	default:
+		s.logger.WarnContext(ctx, "restarted Wasm module", "restart", event.Restart, "cause", event.Cause)
+	}
+
+	if s.opts.onRestart != nil {
+		s.opts.onRestart(event)
+	}
+}
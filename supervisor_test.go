@@ -0,0 +1,249 @@
+package hornet
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"github.com/matryer/is"
+	"github.com/tetratelabs/wazero"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestNewSupervisor(t *testing.T) {
+	ctx := context.Background()
+
+	r := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { _ = r.Close(ctx) })
+
+	t.Run("should reject invalid options", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, opt := range []SupervisorOption{
+			WithSupervisorBackoff(time.Second, time.Millisecond),
+			WithSupervisorBackoff(-time.Second, time.Second),
+			WithSupervisorRestartBudget(-1, time.Minute),
+		} {
+			_, err := NewSupervisor(ctx, r, minimalMemoryModule, opt)
+			is.True(err != nil)
+		}
+	})
+
+	t.Run("should fail to instantiate module that is not a plugin", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := NewSupervisor(ctx, r, minimalMemoryModule)
+		is.True(err != nil)
+	})
+}
+
+func TestSupervisor(t *testing.T) {
+	ctx := context.Background()
+
+	// restartEvent is a RestartEvent reported at a certain time.
+	type restartEvent struct {
+		RestartEvent
+
+		time time.Time
+	}
+
+	newSupervisor := func(
+		t *testing.T,
+		r wazero.Runtime,
+		opt ...SupervisorOption,
+	) (*Supervisor, func() []restartEvent) {
+		t.Helper()
+
+		var (
+			mu     sync.Mutex
+			events []restartEvent
+		)
+
+		opt = append(opt, WithSupervisorOnRestart(func(e RestartEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			events = append(events, restartEvent{RestartEvent: e, time: time.Now()})
+		}))
+
+		s, err := NewSupervisor(ctx, r, testPluginModule(t), opt...)
+		if err != nil {
+			t.Fatalf("failed to create supervisor: %v", err)
+		}
+		t.Cleanup(func() { _ = s.Close(ctx) })
+
+		return s, func() []restartEvent {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return slices.Clone(events)
+		}
+	}
+
+	// echo calls Echo on the supervisor and returns the response value.
+	echo := func(s *Supervisor, value string) (string, error) {
+		resp, err := testsvc.NewTestServiceClient(s).Echo(ctx, wrapperspb.String(value))
+		return resp.GetValue(), err
+	}
+
+	t.Run("should restart module after plugin exits", func(t *testing.T) {
+		is := is.New(t)
+		testPluginModule(t)
+		s, events := newSupervisor(t, newTestRuntime(t, false))
+
+		_, err := echo(s, "hello")
+		is.NoErr(err)
+
+		_, exitErr := echo(s, "exit")
+		is.True(exitErr != nil)
+		is.Equal(len(events()), 0) // the module is restarted on the next call
+
+		calls, err := echo(s, "calls")
+		is.NoErr(err)
+		is.Equal(calls, "1") // new instance
+
+		is.Equal(len(events()), 1)
+		is.Equal(events()[0].Restart, 1)
+		is.Equal(events()[0].Cause, exitErr)
+		is.NoErr(events()[0].Err)
+		is.True(!events()[0].GaveUp)
+	})
+
+	t.Run("should keep module config after restart", func(t *testing.T) {
+		is := is.New(t)
+		s, events := newSupervisor(t, newTestRuntime(t, false),
+			WithSupervisorModuleConfig(wazero.NewModuleConfig().WithName("supervised")),
+		)
+
+		// moduleName returns the name of the module that handled an Echo call.
+		moduleName := func() string {
+			var p peer.Peer
+			_, err := testsvc.NewTestServiceClient(s).Echo(ctx, wrapperspb.String("hello"), grpc.Peer(&p))
+			is.NoErr(err)
+			return p.Addr.String()
+		}
+
+		is.Equal(moduleName(), "supervised")
+
+		_, err := echo(s, "exit")
+		is.True(err != nil)
+
+		is.Equal(moduleName(), "supervised")
+		is.Equal(len(events()), 1)
+	})
+
+	t.Run("should retry call on new instance", func(t *testing.T) {
+		is := is.New(t)
+		testPluginModule(t)
+		s, events := newSupervisor(t, newTestRuntime(t, false),
+			WithSupervisorBackoff(0, 0),
+			WithSupervisorRestartBudget(2, time.Minute),
+			WithSupervisorRetryPolicy(func(method string, _ error) bool {
+				return method == testsvc.EchoFullMethodName
+			}),
+		)
+
+		// The call is retried until the restart budget is exceeded, and the
+		// error of the last attempt is returned.
+		_, err := echo(s, "exit")
+		is.True(err != nil)
+		is.True(!errors.Is(err, ErrRestartBudgetExceeded))
+
+		is.Equal(len(events()), 3)
+		is.True(events()[2].GaveUp)
+	})
+
+	t.Run("should stop restarting module once restart budget is exceeded", func(t *testing.T) {
+		is := is.New(t)
+		testPluginModule(t)
+		s, events := newSupervisor(t, newTestRuntime(t, false),
+			WithSupervisorBackoff(0, 0),
+			WithSupervisorRestartBudget(1, time.Minute),
+		)
+
+		_, err := echo(s, "exit")
+		is.True(err != nil)
+
+		_, err = echo(s, "exit") // restarts the module once
+		is.True(err != nil)
+
+		_, err = echo(s, "hello")
+		is.Equal(err, ErrRestartBudgetExceeded)
+
+		_, err = echo(s, "hello")
+		is.Equal(err, ErrRestartBudgetExceeded)
+
+		is.Equal(len(events()), 2)
+		is.NoErr(events()[0].Err)
+		is.Equal(events()[1].Err, ErrRestartBudgetExceeded)
+		is.True(events()[1].GaveUp)
+	})
+
+	t.Run("should delay consecutive restarts", func(t *testing.T) {
+		is := is.New(t)
+		testPluginModule(t)
+		s, events := newSupervisor(t, newTestRuntime(t, false),
+			WithSupervisorBackoff(100*time.Millisecond, time.Second),
+		)
+
+		_, err := echo(s, "exit")
+		is.True(err != nil)
+
+		// The first restart happens right away, the module dies again before
+		// a call succeeds.
+		_, err = echo(s, "exit")
+		is.True(err != nil)
+
+		_, err = echo(s, "hello")
+		is.NoErr(err)
+
+		is.Equal(len(events()), 2)
+		is.True(events()[1].time.Sub(events()[0].time) >= 100*time.Millisecond)
+
+		// The successful call resets the backoff.
+		is.Equal(s.failedRestarts, 0)
+	})
+
+	t.Run("should not restart module after supervisor is closed", func(t *testing.T) {
+		is := is.New(t)
+		testPluginModule(t)
+		r := newTestRuntime(t, false)
+		s, _ := newSupervisor(t, r,
+			WithSupervisorBackoff(200*time.Millisecond, time.Second),
+			WithSupervisorClientOptions(WithModuleName("supervised")),
+		)
+
+		_, err := echo(s, "exit")
+		is.True(err != nil)
+
+		_, err = echo(s, "exit")
+		is.True(err != nil)
+
+		// The next restart waits for the backoff, close the supervisor in
+		// the meantime.
+		time.AfterFunc(50*time.Millisecond, func() { _ = s.Close(ctx) })
+
+		_, err = echo(s, "hello")
+		is.Equal(err, errSupervisorClosed)
+		is.True(r.Module("supervised") == nil)
+	})
+}
+
+func TestSupervisor_Backoff(t *testing.T) {
+	is := is.New(t)
+
+	s := &Supervisor{opts: defaultSupervisorOptions()}
+	s.opts.minBackoff = time.Second
+	s.opts.maxBackoff = 5 * time.Second
+
+	for failedRestarts, want := range []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		s.failedRestarts = failedRestarts
+		is.Equal(s.backoff(), want)
+	}
+}
@@ -0,0 +1,190 @@
+package hornet
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+type swapOptions struct {
+	drainTimeout time.Duration
+}
+
+func defaultSwapOptions() swapOptions {
+	return swapOptions{
+		drainTimeout: 30 * time.Second,
+	}
+}
+
+var _ grpc.ClientConnInterface = (*SwapConn)(nil)
+
+// SwapConn is a connection to a plugin whose Wasm module can be replaced while
+// the connection is in use, e.g. to upgrade the plugin at runtime. It can be
+// passed to gRPC client constructors generated by protoc-gen-go-grpc, the
+// clients keep working when the module is replaced.
+//
+// Calls are routed to the [ClientConn] that was set last. A replaced
+// ClientConn keeps serving the calls that were in flight when it was replaced,
+// its module is closed once they finish, or once the drain timeout passes,
+// see [WithSwapDrainTimeout].
+type SwapConn struct {
+	opts swapOptions
+
+	mu      sync.RWMutex // guards following fields
+	current *swapTarget
+	closed  bool
+}
+
+// swapTarget is a ClientConn served by a SwapConn.
+type swapTarget struct {
+	conn *ClientConn
+	// inflight counts the calls in progress on the connection.
+	inflight sync.WaitGroup
+}
+
+// NewSwapConn creates a SwapConn that routes calls to the given connection.
+// The SwapConn takes ownership of the connection's module, it is closed when
+// the connection is replaced or the SwapConn is closed.
+func NewSwapConn(conn *ClientConn, opt ...SwapOption) *SwapConn {
+	opts := defaultSwapOptions()
+	for _, o := range opt {
+		o.applySwap(&opts)
+	}
+
+	return &SwapConn{opts: opts, current: &swapTarget{conn: conn}}
+}
+
+// Invoke performs a unary RPC on the current connection.
+func (s *SwapConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	t, err := s.acquire()
+	if err != nil {
+		return err
+	}
+	defer t.inflight.Done()
+
+	return t.conn.Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream begins a streaming RPC on the current connection. The stream stays
+// on that connection until it's finished, even if the connection is replaced.
+func (s *SwapConn) NewStream(
+	ctx context.Context,
+	desc *grpc.StreamDesc,
+	method string,
+	opts ...grpc.CallOption,
+) (grpc.ClientStream, error) {
+	t, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	cs, err := t.conn.NewStream(ctx, desc, method, opts...)
+	if err != nil {
+		t.inflight.Done()
+		return nil, err
+	}
+
+	return newReleaseStream(ctx, cs, t.inflight.Done), nil
+}
+
+// Conn returns the current connection.
+func (s *SwapConn) Conn() *ClientConn {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.current.conn
+}
+
+// Swap routes new calls to the given connection, waits for the calls in
+// flight on the previous connection to finish, and closes the previous
+// connection's module. If ctx is done or the drain timeout passes before the
+// calls finish, the module is closed right away, interrupting the remaining
+// calls, e.g. streams that were abandoned without being cancelled. Swap takes
+// ownership of the given connection's module, it's closed right away if the
+// SwapConn is closed.
+func (s *SwapConn) Swap(ctx context.Context, conn *ClientConn) error {
+	old, err := s.swap(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	return s.drain(ctx, old)
+}
+
+// Close closes the module of the current connection without waiting for calls
+// in flight. Calls after Close fail with the code Unavailable.
+func (s *SwapConn) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.closed = true
+	t := s.current
+	s.mu.Unlock()
+
+	return t.conn.module.Close(ctx)
+}
+
+// acquire returns the current connection and registers a call in flight on
+// it. The caller must call inflight.Done once the call is finished.
+func (s *SwapConn) acquire() (*swapTarget, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return nil, errModuleClosed
+	}
+
+	s.current.inflight.Add(1)
+
+	return s.current, nil
+}
+
+// swap routes new calls to the given connection and returns the replaced one,
+// which must be drained by the caller. If the SwapConn is closed, the module
+// of the given connection is closed and errModuleClosed is returned.
+func (s *SwapConn) swap(ctx context.Context, conn *ClientConn) (*swapTarget, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = conn.module.Close(ctx)
+
+		return nil, errModuleClosed
+	}
+
+	old := s.current
+	s.current = &swapTarget{conn: conn}
+	s.mu.Unlock()
+
+	return old, nil
+}
+
+// drain waits for the calls in flight on the replaced connection to finish and
+// closes its module. If ctx is done or the drain timeout passes before that,
+// the module is closed right away.
+func (s *SwapConn) drain(ctx context.Context, t *swapTarget) error {
+	closeCtx := context.WithoutCancel(ctx)
+
+	if s.opts.drainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.drainTimeout)
+		defer cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	return t.conn.module.Close(closeCtx)
+}
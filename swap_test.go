@@ -0,0 +1,70 @@
+package hornet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/tetratelabs/wazero"
+)
+
+func TestSwapConn(t *testing.T) {
+	ctx := context.Background()
+
+	r := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { _ = r.Close(ctx) })
+
+	// newTestConn returns a ClientConn that is only usable for swapping and
+	// closing, the module is not a plugin.
+	newTestConn := func(t *testing.T) *ClientConn {
+		mod, err := r.Instantiate(ctx, minimalMemoryModule)
+		is.New(t).NoErr(err)
+
+		return &ClientConn{module: mod}
+	}
+
+	t.Run("should close previous module after swap", func(t *testing.T) {
+		is := is.New(t)
+
+		oldConn, newConn := newTestConn(t), newTestConn(t)
+		s := NewSwapConn(oldConn)
+
+		is.NoErr(s.Swap(ctx, newConn))
+		is.Equal(s.Conn(), newConn)
+		is.True(oldConn.module.IsClosed())
+		is.True(!newConn.module.IsClosed())
+
+		is.NoErr(s.Close(ctx))
+		is.True(newConn.module.IsClosed())
+	})
+
+	t.Run("should close previous module after drain timeout", func(t *testing.T) {
+		is := is.New(t)
+
+		oldConn := newTestConn(t)
+		s := NewSwapConn(oldConn, WithSwapDrainTimeout(10*time.Millisecond))
+
+		// A call that never finishes, e.g. an abandoned stream.
+		_, err := s.acquire()
+		is.NoErr(err)
+
+		is.NoErr(s.Swap(ctx, newTestConn(t)))
+		is.True(oldConn.module.IsClosed())
+	})
+
+	t.Run("should reject calls and swaps after close", func(t *testing.T) {
+		is := is.New(t)
+
+		s := NewSwapConn(newTestConn(t))
+		is.NoErr(s.Close(ctx))
+
+		err := s.Invoke(ctx, "/test.Service/Method", nil, nil)
+		is.Equal(err, errModuleClosed)
+
+		conn := newTestConn(t)
+		err = s.Swap(ctx, conn)
+		is.Equal(err, errModuleClosed)
+		is.True(conn.module.IsClosed())
+	})
+}
@@ -0,0 +1,217 @@
+//go:build wasm
+
+// Command plugin is the Wasm plugin used in the tests of Hornet. It implements
+// the test service in testdata/testsvc.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lovromazgon/hornet"
+	"github.com/lovromazgon/hornet/testdata/testsvc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func main() {}
+
+// srv is the server of the plugin.
+var srv = hornet.NewServer(hornet.WithHealth())
+
+func init() {
+	hornet.RegisterError(testsvc.SentinelErrorReason, codes.FailedPrecondition, testsvc.ErrSentinel)
+	hornet.RegisterErrorType(
+		testsvc.LimitErrorReason, codes.InvalidArgument,
+		testsvc.MarshalLimitError, testsvc.UnmarshalLimitError,
+	)
+
+	testsvc.RegisterTestServiceServer(srv, testService{})
+	reflection.RegisterV1(srv)
+	hornet.InitPlugin(srv)
+}
+
+// hostCallTimeout is the timeout of calls to the host, so that the tests can
+// check that the deadline is propagated to the host.
+const hostCallTimeout = time.Minute
+
+// hostClient calls the test service on the host.
+var hostClient = testsvc.NewTestServiceClient(hornet.NewHostConn())
+
+// countInterval is the interval between messages sent by Count if the
+// requested number is negative.
+const countInterval = 10 * time.Millisecond
+
+// logger sends the logs of the plugin to the host.
+var logger = slog.New(hornet.NewLogHandler(slog.LevelDebug))
+
+// echoCalls is the number of calls to Echo handled by this instance of the
+// plugin.
+var echoCalls int
+
+type testService struct{}
+
+// Echo returns the request. The request "error" returns an error with the code
+// InvalidArgument. The requests "sentinel" and "limit" return the registered
+// errors testsvc.ErrSentinel and testsvc.LimitError, and the request "panic"
+// panics. The request "exit" exits the plugin. The request "deadline" waits
+// until the deadline of the call is exceeded, or returns "no deadline" if there
+// is none. The request "timeout" returns the time remaining until the deadline,
+// and the request "loop" never returns. The request "calls" returns the number
+// of calls to Echo handled by this instance, and the request "unhealthy" sets
+// the serving status of the plugin to NOT_SERVING. The request "log" logs a
+// record with nested groups and attributes, a record in empty groups and a
+// record without the context of the call. Requests starting with "host:" are
+// forwarded to the host together with the incoming metadata, and the header
+// returned by the host is sent back.
+func (testService) Echo(ctx context.Context, in *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	echoCalls++
+
+	switch v := in.GetValue(); {
+	case v == "error":
+		return nil, status.Error(codes.InvalidArgument, "echo error")
+	case v == "sentinel":
+		return nil, fmt.Errorf("echo failed: %w", testsvc.ErrSentinel)
+	case v == "limit":
+		return nil, &testsvc.LimitError{Field: "value", Limit: 10}
+	case v == "panic":
+		panic("echo panic")
+	case v == "deadline":
+		if _, ok := ctx.Deadline(); !ok {
+			return wrapperspb.String("no deadline"), nil
+		}
+
+		<-ctx.Done()
+
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case v == "timeout":
+		deadline, _ := ctx.Deadline()
+		return wrapperspb.String(time.Until(deadline).String()), nil
+	case v == "loop":
+		for {
+			runtime.KeepAlive(v)
+		}
+	case v == "exit":
+		os.Exit(1)
+		return nil, nil
+	case v == "calls":
+		return wrapperspb.String(strconv.Itoa(echoCalls)), nil
+	case v == "unhealthy":
+		srv.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		return in, nil
+	case v == "log":
+		logger.With("a", 1).WithGroup("g").With("b", true).WithGroup("h").InfoContext(ctx, "nested", "c", "x")
+		logger.WithGroup("g").WithGroup("empty").WarnContext(ctx, "empty groups")
+		logger.Info("no context")
+
+		return in, nil
+	case strings.HasPrefix(v, "host:"):
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		hostCtx, cancel := context.WithTimeout(metadata.NewOutgoingContext(ctx, md), hostCallTimeout)
+		defer cancel()
+
+		var header metadata.MD
+
+		resp, err := hostClient.Echo(
+			hostCtx,
+			wrapperspb.String(strings.TrimPrefix(v, "host:")),
+			grpc.Header(&header),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = grpc.SetHeader(ctx, header)
+		if err != nil {
+			return nil, err
+		}
+
+		return resp, nil
+	default:
+		return in, nil
+	}
+}
+
+// Count sends the numbers from 0 to n-1. If n is negative, it sends the
+// numbers from 0 to -n-1 waiting countInterval before each of them, and then
+// waits until the stream is cancelled. The header "x-count" contains n.
+func (testService) Count(in *wrapperspb.Int64Value, stream grpc.ServerStreamingServer[wrapperspb.Int64Value]) error {
+	n := in.GetValue()
+
+	err := stream.SetHeader(metadata.Pairs("x-count", strconv.FormatInt(n, 10)))
+	if err != nil {
+		return err
+	}
+	if n >= 0 {
+		for i := range n {
+			if err := stream.Send(wrapperspb.Int64(i)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	for i := range -n {
+		time.Sleep(countInterval)
+
+		if err := stream.Send(wrapperspb.Int64(i)); err != nil {
+			return err
+		}
+	}
+
+	<-stream.Context().Done()
+
+	return status.FromContextError(stream.Context().Err()).Err()
+}
+
+// Sum returns the sum of the received numbers.
+func (testService) Sum(stream grpc.ClientStreamingServer[wrapperspb.Int64Value, wrapperspb.Int64Value]) error {
+	var sum int64
+
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(wrapperspb.Int64(sum))
+		}
+
+		if err != nil {
+			return err
+		}
+
+		sum += msg.GetValue()
+	}
+}
+
+// Chat responds to every received message with the message in upper case.
+func (testService) Chat(stream grpc.BidiStreamingServer[wrapperspb.StringValue, wrapperspb.StringValue]) error {
+	for {
+		msg, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		err = stream.Send(wrapperspb.String(strings.ToUpper(msg.GetValue())))
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package testsvc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Reasons of the errors returned by the test plugin. The errors are
+// registered in the plugin and in the tests, as this package can't import
+// hornet without creating an import cycle in the tests.
+const (
+	SentinelErrorReason = "hornet.testdata.Sentinel"
+	LimitErrorReason    = "hornet.testdata.Limit"
+)
+
+// ErrSentinel is a sentinel error returned by the test plugin.
+var ErrSentinel = errors.New("test sentinel")
+
+// LimitError is a structured error returned by the test plugin.
+type LimitError struct {
+	Field string
+	Limit int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("field %s exceeds limit %d", e.Field, e.Limit)
+}
+
+// MarshalLimitError encodes a LimitError as the metadata of an error status.
+func MarshalLimitError(e *LimitError) map[string]string {
+	return map[string]string{"field": e.Field, "limit": strconv.Itoa(e.Limit)}
+}
+
+// UnmarshalLimitError decodes a LimitError from the metadata of an error
+// status.
+func UnmarshalLimitError(md map[string]string) *LimitError {
+	limit, _ := strconv.Atoi(md["limit"])
+	return &LimitError{Field: md["field"], Limit: limit}
+}
@@ -0,0 +1,216 @@
+// Package testsvc contains the description of the service implemented by the
+// test plugin in testdata/plugin. It's written by hand to mirror the code
+// generated by protoc-gen-go-grpc, so that no generated code is needed in the
+// tests.
+package testsvc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	ServiceName = "hornet.testdata.TestService"
+
+	EchoFullMethodName  = "/" + ServiceName + "/Echo"
+	CountFullMethodName = "/" + ServiceName + "/Count"
+	SumFullMethodName   = "/" + ServiceName + "/Sum"
+	ChatFullMethodName  = "/" + ServiceName + "/Chat"
+)
+
+// TestServiceServer is the server API for the test service.
+type TestServiceServer interface {
+	// Echo returns the request.
+	Echo(context.Context, *wrapperspb.StringValue) (*wrapperspb.StringValue, error)
+	// Count streams the numbers from 0 to the request.
+	Count(*wrapperspb.Int64Value, grpc.ServerStreamingServer[wrapperspb.Int64Value]) error
+	// Sum returns the sum of the streamed numbers.
+	Sum(grpc.ClientStreamingServer[wrapperspb.Int64Value, wrapperspb.Int64Value]) error
+	// Chat responds to every streamed message.
+	Chat(grpc.BidiStreamingServer[wrapperspb.StringValue, wrapperspb.StringValue]) error
+}
+
+// UnimplementedTestServiceServer can be embedded to have forward compatible
+// implementations.
+type UnimplementedTestServiceServer struct{}
+
+func (UnimplementedTestServiceServer) Echo(context.Context, *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	return nil, status.Error(codes.Unimplemented, "method Echo not implemented")
+}
+
+func (UnimplementedTestServiceServer) Count(
+	*wrapperspb.Int64Value,
+	grpc.ServerStreamingServer[wrapperspb.Int64Value],
+) error {
+	return status.Error(codes.Unimplemented, "method Count not implemented")
+}
+
+func (UnimplementedTestServiceServer) Sum(
+	grpc.ClientStreamingServer[wrapperspb.Int64Value, wrapperspb.Int64Value],
+) error {
+	return status.Error(codes.Unimplemented, "method Sum not implemented")
+}
+
+func (UnimplementedTestServiceServer) Chat(
+	grpc.BidiStreamingServer[wrapperspb.StringValue, wrapperspb.StringValue],
+) error {
+	return status.Error(codes.Unimplemented, "method Chat not implemented")
+}
+
+// RegisterTestServiceServer registers the implementation of the test service.
+func RegisterTestServiceServer(s grpc.ServiceRegistrar, srv TestServiceServer) {
+	s.RegisterService(&TestService_ServiceDesc, srv)
+}
+
+// TestService_ServiceDesc is the grpc.ServiceDesc of the test service.
+var TestService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*TestServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Echo",
+			Handler:    echoHandler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Count",
+			Handler:       countHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Sum",
+			Handler:       sumHandler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Chat",
+			Handler:       chatHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+func echoHandler(
+	srv any,
+	ctx context.Context,
+	dec func(any) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (any, error) {
+	in := new(wrapperspb.StringValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+
+	if interceptor == nil {
+		return srv.(TestServiceServer).Echo(ctx, in)
+	}
+
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: EchoFullMethodName,
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(TestServiceServer).Echo(ctx, req.(*wrapperspb.StringValue))
+	}
+
+	return interceptor(ctx, in, info, handler)
+}
+
+func countHandler(srv any, stream grpc.ServerStream) error {
+	m := new(wrapperspb.Int64Value)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	return srv.(TestServiceServer).Count(m, &grpc.GenericServerStream[wrapperspb.Int64Value, wrapperspb.Int64Value]{
+		ServerStream: stream,
+	})
+}
+
+func sumHandler(srv any, stream grpc.ServerStream) error {
+	return srv.(TestServiceServer).Sum(&grpc.GenericServerStream[wrapperspb.Int64Value, wrapperspb.Int64Value]{
+		ServerStream: stream,
+	})
+}
+
+func chatHandler(srv any, stream grpc.ServerStream) error {
+	return srv.(TestServiceServer).Chat(&grpc.GenericServerStream[wrapperspb.StringValue, wrapperspb.StringValue]{
+		ServerStream: stream,
+	})
+}
+
+// TestServiceClient is the client API for the test service.
+type TestServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTestServiceClient(cc grpc.ClientConnInterface) *TestServiceClient {
+	return &TestServiceClient{cc: cc}
+}
+
+func (c *TestServiceClient) Echo(
+	ctx context.Context,
+	in *wrapperspb.StringValue,
+	opts ...grpc.CallOption,
+) (*wrapperspb.StringValue, error) {
+	out := new(wrapperspb.StringValue)
+
+	err := c.cc.Invoke(ctx, EchoFullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *TestServiceClient) Count(
+	ctx context.Context,
+	in *wrapperspb.Int64Value,
+	opts ...grpc.CallOption,
+) (grpc.ServerStreamingClient[wrapperspb.Int64Value], error) {
+	stream, err := c.cc.NewStream(ctx, &TestService_ServiceDesc.Streams[0], CountFullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &grpc.GenericClientStream[wrapperspb.Int64Value, wrapperspb.Int64Value]{ClientStream: stream}
+	if err := x.SendMsg(in); err != nil {
+		return nil, err
+	}
+
+	if err := x.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	return x, nil
+}
+
+func (c *TestServiceClient) Sum(
+	ctx context.Context,
+	opts ...grpc.CallOption,
+) (grpc.ClientStreamingClient[wrapperspb.Int64Value, wrapperspb.Int64Value], error) {
+	stream, err := c.cc.NewStream(ctx, &TestService_ServiceDesc.Streams[1], SumFullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpc.GenericClientStream[wrapperspb.Int64Value, wrapperspb.Int64Value]{ClientStream: stream}, nil
+}
+
+func (c *TestServiceClient) Chat(
+	ctx context.Context,
+	opts ...grpc.CallOption,
+) (grpc.BidiStreamingClient[wrapperspb.StringValue, wrapperspb.StringValue], error) {
+	stream, err := c.cc.NewStream(ctx, &TestService_ServiceDesc.Streams[2], ChatFullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpc.GenericClientStream[wrapperspb.StringValue, wrapperspb.StringValue]{ClientStream: stream}, nil
+}
@@ -3,11 +3,11 @@
 package hornet
 
 import (
+	"context"
 	"unsafe"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-	"google.golang.org/protobuf/proto"
 )
 
 // mallocBuffer is a reusable buffer for exchanging data with the Wasm host.
@@ -24,6 +24,26 @@ func malloc(size uint32) uintptr {
 	return mallocBuffer.Pointer()
 }
 
+// version gets called by the host to negotiate the protocol version. It
+// returns the highest protocol version supported by the plugin.
+//
+//go:wasmexport hornet-version
+func version() uint32 {
+	return protocolVersion
+}
+
+// features gets called by the host after negotiating the protocol version. It
+// returns the bit set of optional features supported by the plugin handler.
+//
+//go:wasmexport hornet-features
+func features() uint64 {
+	if _, ok := handler.(envelopeHandler); ok {
+		return uint64(featureStreams | featureMetadata)
+	}
+
+	return 0
+}
+
 // command gets called by the host to execute a command in the Wasm plugin.
 // It receives a pointer to a memory buffer that contains the method name and
 // the request payload. The method name is of length methodSize, and the rest
@@ -43,6 +63,151 @@ func command(ptr uintptr, methodSize, bufferSize uint32) uint64 {
 	return (*buffer)(&output).PointerAndSize()
 }
 
+// commandV2 gets called by the host to execute a command in the Wasm plugin
+// using the v2 protocol. It receives a pointer to a memory buffer that contains
+// the request envelope, where the request header is of length headerSize and
+// the rest of the buffer is the request payload. It returns a pointer to a
+// memory buffer that contains the response envelope and its size in a uint64
+// value, the same way as command.
+//
+//go:wasmexport hornet-v2-command
+func commandV2(ptr uintptr, headerSize, bufferSize uint32) uint64 {
+	input := unsafe.Slice((*byte)(unsafe.Pointer(ptr)), bufferSize)
+
+	header := input[:headerSize]
+	req := input[headerSize:]
+
+	var output []byte
+	if eh, ok := handler.(envelopeHandler); ok {
+		// Start a new context for each request.
+		output = eh.handleEnvelope(context.Background(), header, req)
+	} else {
+		output = handleEnvelopeV1(handler, header, req)
+	}
+
+	return (*buffer)(&output).PointerAndSize()
+}
+
+// streamOpen gets called by the host to open a stream in the Wasm plugin. It
+// receives the request envelope the same way as commandV2. The request payload
+// is only used for server-streaming RPCs, for other streams the host sends
+// messages using streamSend. It returns a pointer to a memory buffer that
+// contains a response envelope with the ID of the opened stream and its size
+// in a uint64 value, the same way as command.
+//
+//go:wasmexport hornet-v2-stream-open
+func streamOpen(ptr uintptr, headerSize, bufferSize uint32) uint64 {
+	input := unsafe.Slice((*byte)(unsafe.Pointer(ptr)), bufferSize)
+
+	header := input[:headerSize]
+	req := input[headerSize:]
+
+	eh, ok := handler.(envelopeHandler)
+	if !ok {
+		output := buffer(errStreamsNotSupported)
+		return output.PointerAndSize()
+	}
+
+	output := eh.openStream(header, req)
+	return (*buffer)(&output).PointerAndSize()
+}
+
+// streamSend gets called by the host to send a message to the stream with the
+// given ID. It receives a pointer to a memory buffer that contains the message
+// payload of length bufferSize. It returns a pointer to a memory buffer that
+// contains the response envelope and its size in a uint64 value, the same way
+// as command.
+//
+//go:wasmexport hornet-v2-stream-send
+func streamSend(streamID uint32, ptr uintptr, bufferSize uint32) uint64 {
+	req := unsafe.Slice((*byte)(unsafe.Pointer(ptr)), bufferSize)
+
+	eh, ok := handler.(envelopeHandler)
+	if !ok {
+		output := buffer(errStreamsNotSupported)
+		return output.PointerAndSize()
+	}
+
+	output := eh.sendStream(streamID, req)
+	return (*buffer)(&output).PointerAndSize()
+}
+
+// streamCloseSend gets called by the host to close the request stream of the
+// stream with the given ID.
+//
+//go:wasmexport hornet-v2-stream-close-send
+func streamCloseSend(streamID uint32) {
+	if eh, ok := handler.(envelopeHandler); ok {
+		eh.closeSendStream(streamID)
+	}
+}
+
+// streamRecv gets called by the host to receive the next message from the
+// stream with the given ID. It does not block, if the stream handler did not
+// send a message, wait for the next message from the host or return, the host
+// is told to try again later. It returns a pointer to a memory buffer that
+// contains the response envelope and its size in a uint64 value, the same way
+// as command.
+//
+//go:wasmexport hornet-v2-stream-recv
+func streamRecv(streamID uint32) uint64 {
+	eh, ok := handler.(envelopeHandler)
+	if !ok {
+		output := buffer(errStreamsNotSupported)
+		return output.PointerAndSize()
+	}
+
+	output := eh.recvStream(streamID)
+	return (*buffer)(&output).PointerAndSize()
+}
+
+// streamClose gets called by the host to close the stream with the given ID.
+// It cancels the context of the stream handler.
+//
+//go:wasmexport hornet-v2-stream-close
+func streamClose(streamID uint32) {
+	if eh, ok := handler.(envelopeHandler); ok {
+		eh.closeStream(streamID)
+	}
+}
+
+// envelopeHandler is implemented by plugin handlers that support the v2
+// protocol, like [Server]. It handles request envelopes containing metadata
+// and streams.
+type envelopeHandler interface {
+	handleEnvelope(ctx context.Context, header, req []byte) (resp []byte)
+	openStream(header, req []byte) (resp []byte)
+	sendStream(id uint32, req []byte) (resp []byte)
+	closeSendStream(id uint32)
+	recvStream(id uint32) (resp []byte)
+	closeStream(id uint32)
+}
+
+// handleEnvelopeV1 handles a request envelope using a handler that only
+// supports the v1 protocol. The metadata in the request header is dropped and
+// the response is converted into a response envelope.
+func handleEnvelopeV1(h PluginHandler, header, req []byte) []byte {
+	var hdr requestHeader
+
+	err := hdr.unmarshal(header)
+	if err != nil {
+		return encodeErrorEnvelope(status.New(codes.Internal, "malformed request header"), nil)
+	}
+
+	resp := h.Handle(hdr.method, req)
+	if len(resp) == 0 {
+		return encodeErrorEnvelope(status.New(codes.Internal, "empty response from plugin handler"), nil)
+	}
+
+	return append(appendResponseEnvelope(nil, resp[0], nil), resp[1:]...)
+}
+
+// errStreamsNotSupported is returned by the stream functions if the plugin
+// handler does not support streams.
+var errStreamsNotSupported = encodeErrorEnvelope(
+	status.New(codes.Unimplemented, "plugin handler does not support streams"), nil,
+)
+
 // PluginHandler is the bridge between the WebAssembly exported functions and
 // the Wasm plugin implementation.
 type PluginHandler interface {
@@ -70,9 +235,10 @@ func InitPlugin(h PluginHandler) {
 var handler PluginHandler = pluginHandlerFunc(func(string, []byte) []byte {
 	// Default handler that returns an unimplemented error.
 	// This will be used if InitPlugin() was not called in the Wasm plugin.
-	st := status.New(codes.Unimplemented, "no plugin handler set, call hornet.InitPlugin() in the Wasm plugin to set a handler")
-	out, _ := proto.Marshal(st.Proto())
-	return out
+	return encodeError(status.New(
+		codes.Unimplemented,
+		"no plugin handler set, call hornet.InitPlugin() in the Wasm plugin to set a handler",
+	))
 })
 
 // pluginHandlerFunc wraps a function that handles a plugin request into an
@@ -0,0 +1,205 @@
+package hornet
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type watchOptions struct {
+	interval    time.Duration
+	healthCheck func(context.Context, *ClientConn) error
+	onReload    func(name string, err error)
+}
+
+func defaultWatchOptions() watchOptions {
+	return watchOptions{
+		interval: time.Second,
+	}
+}
+
+// Watch polls the files of plugins loaded using [Manager.LoadFile] and
+// [Manager.LoadFS] and reloads plugins whose file changed. New files with the
+// .wasm extension in file systems passed to LoadFS are loaded as new plugins.
+// Watch blocks until ctx is done or the manager is closed.
+//
+// A changed file is compiled and instantiated, and checked using the function
+// passed to [WithWatchHealthCheck]. Only then, the new module replaces the
+// previous one behind the connection of the plugin, so clients created before
+// the reload keep working. Calls in flight finish on the previous module,
+// which is closed afterwards. If the new module fails to load, the previous
+// one is kept and the error is logged and passed to the function set using
+// [WithWatchOnReload]. The file is not loaded again until it changes.
+func (m *Manager) Watch(ctx context.Context, opt ...WatchOption) {
+	opts := defaultWatchOptions()
+	for _, o := range opt {
+		o.applyWatch(&opts)
+	}
+
+	w := &watcher{
+		m:      m,
+		opts:   opts,
+		failed: make(map[string]fileStat),
+	}
+	// Replaced modules are closed once their calls finish, or when ctx is
+	// done, wait for them before returning.
+	defer w.drains.Wait()
+
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if m.isClosed() {
+			return
+		}
+
+		w.poll(ctx)
+	}
+}
+
+// watcher holds the state of a call to Manager.Watch.
+type watcher struct {
+	m    *Manager
+	opts watchOptions
+	// failed contains the stats of new files in watched file systems that
+	// failed to load, they are loaded again once they change.
+	failed map[string]fileStat
+	drains sync.WaitGroup
+}
+
+// poll reloads changed plugins and loads new plugins.
+func (w *watcher) poll(ctx context.Context) {
+	for _, p := range w.m.Plugins() {
+		if p.file == nil {
+			continue
+		}
+
+		stat, err := statFile(p.file.fsys, p.file.path)
+		if err != nil {
+			// The file may be in the middle of being replaced, keep the
+			// plugin and try again in the next poll.
+			continue
+		}
+
+		p.file.mu.Lock()
+		changed := stat != p.file.stat
+		p.file.stat = stat
+		p.file.mu.Unlock()
+
+		if changed {
+			w.report(ctx, p.name, true, w.reload(ctx, p))
+		}
+	}
+
+	w.m.mu.Lock()
+	dirs := slices.Clone(w.m.dirs)
+	w.m.mu.Unlock()
+
+	for _, dir := range dirs {
+		w.loadNew(ctx, dir)
+	}
+}
+
+// reload replaces the module of the plugin with a module instantiated from the
+// plugin's file.
+func (w *watcher) reload(ctx context.Context, p *Plugin) error {
+	source, err := fs.ReadFile(p.file.fsys, p.file.path)
+	if err != nil {
+		return fmt.Errorf("failed to read plugin %q: %w", p.name, err)
+	}
+
+	module, conn, err := w.m.instantiate(ctx, p.name, source, p.file.opts)
+	if err != nil {
+		return err
+	}
+
+	if w.opts.healthCheck != nil {
+		if err := w.opts.healthCheck(ctx, conn); err != nil {
+			_ = module.Close(ctx)
+			return fmt.Errorf("plugin %q failed health check: %w", p.name, err)
+		}
+	}
+
+	old, err := p.conn.swap(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("failed to reload plugin %q: %w", p.name, err)
+	}
+
+	w.drains.Go(func() {
+		err := p.conn.drain(ctx, old)
+		if err != nil {
+			w.m.logger.WarnContext(ctx, "failed to close replaced plugin module", "plugin", p.name, "error", err)
+		}
+	})
+
+	return nil
+}
+
+// loadNew loads the files in the file system that are not loaded yet.
+func (w *watcher) loadNew(ctx context.Context, dir pluginDir) {
+	names, err := fs.Glob(dir.fsys, "*"+wasmExt)
+	if err != nil {
+		w.m.logger.WarnContext(ctx, "failed to list plugins", "error", err)
+		return
+	}
+
+	for _, name := range names {
+		pluginName := strings.TrimSuffix(path.Base(name), wasmExt)
+		if _, ok := w.m.Plugin(pluginName); ok {
+			continue
+		}
+
+		stat, err := statFile(dir.fsys, name)
+		if err != nil {
+			continue
+		}
+
+		if failedStat, ok := w.failed[name]; ok && failedStat == stat {
+			continue
+		}
+
+		_, err = w.m.loadFile(ctx, dir.fsys, name, dir.opts)
+		if err != nil {
+			w.failed[name] = stat
+		} else {
+			delete(w.failed, name)
+		}
+
+		w.report(ctx, pluginName, false, err)
+	}
+}
+
+// report logs the result of loading a plugin and passes it to the function set
+// using WithWatchOnReload.
+func (w *watcher) report(ctx context.Context, name string, reload bool, err error) {
+	if ctx.Err() != nil {
+		// Watch is stopping, the error is not caused by the plugin.
+		return
+	}
+
+	switch {
+	case err == nil && reload:
+		w.m.logger.InfoContext(ctx, "reloaded plugin", "plugin", name)
+	case err == nil:
+		w.m.logger.InfoContext(ctx, "loaded new plugin", "plugin", name)
+	case reload:
+		w.m.logger.ErrorContext(ctx, "failed to reload plugin, keeping previous version", "plugin", name, "error", err)
+	default:
+		w.m.logger.ErrorContext(ctx, "failed to load new plugin", "plugin", name, "error", err)
+	}
+
+	if w.opts.onReload != nil {
+		w.opts.onReload(name, err)
+	}
+}
@@ -0,0 +1,54 @@
+package hornet
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestManager_Watch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should report new plugin that fails to load once", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := NewManager(ctx, WithManagerClientOptions(WithLogger(slog.New(slog.DiscardHandler))))
+		is.NoErr(err)
+		t.Cleanup(func() { _ = m.Close(ctx) })
+
+		_, err = m.LoadFS(ctx, fstest.MapFS{"a.wasm": {Data: []byte("not wasm")}})
+		is.True(err != nil)
+
+		var reports atomic.Int32
+
+		watchCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		m.Watch(watchCtx,
+			WithWatchInterval(10*time.Millisecond),
+			WithWatchOnReload(func(name string, err error) {
+				is.Equal(name, "a")
+				is.True(err != nil)
+				reports.Add(1)
+			}),
+		)
+
+		is.Equal(reports.Load(), int32(1))
+	})
+
+	t.Run("should stop when manager is closed", func(t *testing.T) {
+		is := is.New(t)
+
+		m, err := NewManager(ctx)
+		is.NoErr(err)
+		is.NoErr(m.Close(ctx))
+
+		// Returns after the first tick, without waiting for ctx.
+		m.Watch(ctx, WithWatchInterval(time.Millisecond))
+	})
+}